@@ -0,0 +1,306 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/google/go-querystring/query"
+)
+
+// The goshopping client only covers part of the API. The helpers in this file
+// implement the missing endpoints on top of its NewRequest and Do methods.
+
+// Kinds of resources that carry power-editor content
+const (
+	kindProduct          = "product"
+	kindPage             = "page"
+	kindArticle          = "article"
+	kindCustomCollection = "custom_collection"
+	kindSmartCollection  = "smart_collection"
+	kindVariant          = "variant"
+	kindShop             = "shop"
+)
+
+// contentRef identifies a resource with power-editor content in a store
+type contentRef struct {
+	kind     string
+	id       int
+	parentId int // the blog of an article or the product of a variant
+}
+
+// path returns the API path of the resource
+func (r contentRef) path() string {
+	switch r.kind {
+	case kindArticle:
+		return fmt.Sprintf("blogs/%d/articles/%d", r.parentId, r.id)
+	case kindVariant:
+		return fmt.Sprintf("products/%d/variants/%d", r.parentId, r.id)
+	}
+	return fmt.Sprintf("%ss/%d", r.kind, r.id)
+}
+
+// owner returns the API path the metafields of the resource are attached to. Shop
+// metafields live at the root of the API, so their owner path is empty.
+func (r contentRef) owner() string {
+	if r.kind == kindShop {
+		return ""
+	}
+	// Custom and smart collections share their metafield endpoints
+	if r.kind == kindCustomCollection || r.kind == kindSmartCollection {
+		return fmt.Sprintf("collections/%d", r.id)
+	}
+	return r.path()
+}
+
+// hasContent tells if the resource has a title, body and SEO tags besides its metafields
+func (r contentRef) hasContent() bool {
+	return r.kind != kindShop
+}
+
+func (r contentRef) String() string {
+	if r.kind == kindShop {
+		return r.kind
+	}
+	return fmt.Sprintf("%s %d", r.kind, r.id)
+}
+
+// content holds the properties that all resources with power-editor content share
+type content struct {
+	Id                             *int    `json:"id,omitempty"`
+	Handle                         *string `json:"handle,omitempty"`
+	Title                          *string `json:"title,omitempty"`
+	BodyHtml                       *string `json:"body_html,omitempty"`
+	MetafieldsGlobalTitleTag       *string `json:"metafields_global_title_tag,omitempty"`
+	MetafieldsGlobalDescriptionTag *string `json:"metafields_global_description_tag,omitempty"`
+}
+
+// contentListOptions are the parameters of the list endpoints of pages, blogs and articles
+type contentListOptions struct {
+	Handle string   `url:"handle,omitempty"`
+	Title  string   `url:"title,omitempty"`
+	Fields []string `url:"fields,comma,omitempty"`
+	shopify.ListOptions
+}
+
+// metafieldListOptions adds the page size to the metafield list parameters
+type metafieldListOptions struct {
+	shopify.MetafieldListOptions
+	Limit int `url:"limit,omitempty"`
+}
+
+type metafieldContainer struct {
+	Metafield *shopify.Metafield `json:"metafield"`
+}
+
+// addOptions adds the parameters in opt as URL query parameters to s
+func addOptions(s string, opt interface{}) (string, error) {
+	if opt == nil {
+		return s, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s, err
+	}
+	qs, err := query.Values(opt)
+	if err != nil {
+		return s, err
+	}
+	u.RawQuery = qs.Encode()
+	return u.String(), nil
+}
+
+// apiGet fetches the API resource at path and decodes it into v
+func apiGet(path string, opt interface{}, v interface{}, client *shopify.Client) error {
+	u, err := addOptions(path, opt)
+	if err != nil {
+		return err
+	}
+	req, err := client.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(context.Background(), req, v)
+	return err
+}
+
+// listContent fetches all pages of a list endpoint like "pages.json". root is the
+// name of the list in the response, e.g. "pages".
+func listContent(path string, root string, opt *contentListOptions, client *shopify.Client) ([]*content, error) {
+	if opt == nil {
+		opt = &contentListOptions{}
+	}
+	opt.Limit = 250
+	var all []*content
+	for opt.Page = 1; ; opt.Page++ {
+		var list map[string][]*content
+		if err := apiGet(path, opt, &list, client); err != nil {
+			return all, err
+		}
+		all = append(all, list[root]...)
+		if len(list[root]) < opt.Limit {
+			return all, nil
+		}
+	}
+}
+
+// findContentId returns the ID of the only resource of a kind whose handle or title
+// (propertyName) has the given value. parentId is the blog of an article.
+func findContentId(kind string, parentId int, propertyName string, propertyValue string, store Store) (int, error) {
+	root := kind + "s"
+	opt := &contentListOptions{Fields: []string{"id"}}
+	switch propertyName {
+	case "handle":
+		opt.Handle = propertyValue
+	case "title":
+		opt.Title = propertyValue
+	}
+	list, err := store.ListContent(kind, parentId, opt)
+	if err != nil {
+		return 0, fmt.Errorf("Can't find %s with %s '%s': %s", root, propertyName, propertyValue, err)
+	}
+	if len(list) > 1 {
+		return 0, fmt.Errorf("Found more than one of %s with %s '%s'", root, propertyName, propertyValue)
+	}
+	if len(list) == 0 {
+		return 0, fmt.Errorf("Found none of %s with %s '%s'", root, propertyName, propertyValue)
+	}
+	return *list[0].Id, nil
+}
+
+// getCollection fetches a collection, which is either a custom or a smart collection
+func getCollection(collectionID int, store Store) (contentRef, *content, error) {
+	var err error
+	for _, kind := range []string{kindCustomCollection, kindSmartCollection} {
+		ref := contentRef{kind: kind, id: collectionID}
+		var c *content
+		if c, err = store.GetContent(ref); err == nil {
+			return ref, c, nil
+		}
+	}
+	return contentRef{}, nil, fmt.Errorf("Can't fetch collection %d: %s", collectionID, err)
+}
+
+// getContent fetches the shared content properties of a resource
+func getContent(ref contentRef, client *shopify.Client) (*content, error) {
+	var v map[string]*content
+	if err := apiGet(ref.path()+".json", nil, &v, client); err != nil {
+		return nil, fmt.Errorf("Can't fetch %s: %s", ref, err)
+	}
+	if v[ref.kind] == nil {
+		return nil, fmt.Errorf("Found no %s", ref)
+	}
+	return v[ref.kind], nil
+}
+
+// editContent writes the title, body and SEO tags of c to a resource. Properties
+// that are nil are left untouched.
+func editContent(ref contentRef, c *content, client *shopify.Client) error {
+	update := &content{
+		Id:                             &ref.id,
+		Title:                          c.Title,
+		BodyHtml:                       c.BodyHtml,
+		MetafieldsGlobalTitleTag:       c.MetafieldsGlobalTitleTag,
+		MetafieldsGlobalDescriptionTag: c.MetafieldsGlobalDescriptionTag,
+	}
+	req, err := client.NewRequest("PUT", ref.path()+".json", map[string]*content{ref.kind: update})
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(context.Background(), req, nil)
+	return err
+}
+
+// metafieldsPath returns the path of the metafield endpoint of an owner path, with an
+// optional suffix like "/123" for a single metafield
+func metafieldsPath(owner string, suffix string) string {
+	if owner == "" {
+		return "metafields" + suffix + ".json"
+	}
+	return owner + "/metafields" + suffix + ".json"
+}
+
+// listMetafields returns the metafields of a namespace attached to the resource at the owner path
+func listMetafields(owner string, namespace string, client *shopify.Client) ([]*shopify.Metafield, error) {
+	opt := &metafieldListOptions{Limit: 250}
+	opt.Namespace = namespace
+	opt.Fields = []string{"id", "key", "value"}
+	// A page holds 250 metafields at most, the next one starts after the last ID
+	var metafields []*shopify.Metafield
+	for {
+		var list shopify.MetafieldList
+		if err := apiGet(metafieldsPath(owner, ""), opt, &list, client); err != nil {
+			return nil, err
+		}
+		metafields = append(metafields, list.Metafields...)
+		if len(list.Metafields) < opt.Limit {
+			return metafields, nil
+		}
+		last := list.Metafields[len(list.Metafields)-1]
+		if last.Id == nil {
+			return nil, errors.New("can't page through metafields without IDs")
+		}
+		opt.SinceId = *last.Id
+	}
+}
+
+// getSeoTags returns the SEO title and description of a resource
+func getSeoTags(ref contentRef, store Store) (globalTitleTag *string, globalDescriptionTag *string, err error) {
+	globalMetafields, err := store.ListMetafields(ref, "global")
+	for _, field := range globalMetafields {
+		if *field.Key == "title_tag" {
+			globalTitleTag = field.Value
+		}
+		if *field.Key == "description_tag" {
+			globalDescriptionTag = field.Value
+		}
+	}
+	return globalTitleTag, globalDescriptionTag, err
+}
+
+// createMetafield adds a new metafield to the resource at the owner path
+func createMetafield(owner string, metafield *shopify.Metafield, client *shopify.Client) error {
+	req, err := client.NewRequest("POST", metafieldsPath(owner, ""), &metafieldContainer{Metafield: metafield})
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(context.Background(), req, nil)
+	return err
+}
+
+// updateMetafield sets the value of an existing metafield of the resource at the owner path
+func updateMetafield(owner string, metafieldID int, value *string, client *shopify.Client) error {
+	u := metafieldsPath(owner, fmt.Sprintf("/%d", metafieldID))
+	// Key and namespace can't be changed, so only send the value
+	body := map[string]interface{}{
+		"metafield": map[string]interface{}{"id": metafieldID, "value": value, "value_type": "string"},
+	}
+	req, err := client.NewRequest("PUT", u, body)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(context.Background(), req, nil)
+	return err
+}
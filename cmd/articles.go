@@ -0,0 +1,123 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/caarlos0/spin"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var blogId int
+
+// blogCmd represents the "export blog" command
+var blogCmd = &cobra.Command{
+	Use:   "blog <blog id>",
+	Short: "export the power-editor content of a blog's articles",
+
+	// Do all the error handling pre run
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+
+		// Check for required API credentials
+		errorMsg := checkGlobalRequiredFlags("export")
+
+		// Check for required blog ID
+		if len(args) < 1 {
+			errorMsg = append(errorMsg, "a blog ID is required as an argument")
+		} else {
+			var err error
+			if blogId, err = strconv.Atoi(args[0]); err != nil {
+				errorMsg = append(errorMsg, fmt.Sprintf("'%s' is not a valid blog ID", args[0]))
+			}
+		}
+		return requiredFlagsError(errorMsg)
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := GetStore("export")
+		blog, err := store.GetContent(contentRef{kind: "blog", id: blogId})
+		if err != nil {
+			return err
+		}
+		return exportArticles([]*content{blog}, store)
+	},
+}
+
+// articlesCmd represents the "export articles" command
+var articlesCmd = &cobra.Command{
+	Use:   "articles",
+	Short: "export the power-editor content of the articles of all blogs",
+
+	// Do all the error handling pre run
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check for required API credentials
+		return requiredFlagsError(checkGlobalRequiredFlags("export"))
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := GetStore("export")
+		blogs, err := store.ListContent("blog", 0, &contentListOptions{Fields: []string{"id", "handle"}})
+		if err != nil {
+			return err
+		}
+		return exportArticles(blogs, store)
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(blogCmd)
+	exportCmd.AddCommand(articlesCmd)
+}
+
+// exportArticles writes the articles of the given blogs to the output file
+func exportArticles(blogs []*content, store Store) error {
+	output := newOutput(store, viper.GetString("export.namespace"))
+	for _, blog := range blogs {
+		s := spin.New("  \033[36m Scanning blog " + *blog.Handle + " \033[m %s")
+		s.Set(spin.Spin1)
+		s.Start()
+		opt := &contentListOptions{Fields: []string{"id", "handle", "title", "body_html"}}
+		articles, err := store.ListContent(kindArticle, *blog.Id, opt)
+		s.Stop()
+		if err != nil {
+			return err
+		}
+
+		for i, article := range articles {
+			progress := fmt.Sprintf("%d of %d", i, len(articles))
+			s = spin.New("  \033[36m Fetching article " + progress + "\033[m %s")
+			s.Set(spin.Spin1)
+			s.Start()
+			ref := contentRef{kind: kindArticle, id: *article.Id, parentId: *blog.Id}
+			fields, err := exportContent(ref, article, store)
+			s.Stop()
+			if err != nil {
+				return err
+			}
+			output.Articles = append(output.Articles, newArticleOutput(blog, article, fields))
+		}
+	}
+
+	return writeExport(&output)
+}
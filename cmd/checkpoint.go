@@ -0,0 +1,175 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// checkpoint records the progress of a long export or import, so that an interrupted run
+// can be resumed with --resume instead of starting over
+type checkpoint struct {
+	fileName string
+
+	Command string `json:"command"`
+	// Params are the options of the run. A run can only be resumed with the same options.
+	Params map[string]string `json:"params"`
+	// Done is the number of products completed so far
+	Done          int  `json:"done"`
+	LastProductId *int `json:"last_product_id,omitempty"`
+	// Failed are the indexes of the records by kind that couldn't be imported, so a resumed run retries them
+	Failed map[string][]int `json:"failed,omitempty"`
+	// Offset is the size of a streamed output file at the checkpoint
+	Offset    int64     `json:"offset,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// checkpointFile returns the name of the checkpoint file of an output or data file
+func checkpointFile(fileName string) string {
+	return strings.TrimSuffix(fileName, string(os.PathSeparator)) + ".checkpoint"
+}
+
+// newCheckpoint returns an empty checkpoint for a run of command with the given options
+func newCheckpoint(fileName string, command string, params map[string]string) *checkpoint {
+	return &checkpoint{fileName: fileName, Command: command, Params: params}
+}
+
+// loadCheckpoint reads the checkpoint of an interrupted run. It fails if the run
+// had other options than the one that is about to resume it.
+func loadCheckpoint(fileName string, command string, params map[string]string) (*checkpoint, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("there is no checkpoint %s to resume from", fileName)
+	} else if err != nil {
+		return nil, err
+	}
+	c := &checkpoint{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("can't read checkpoint %s: %s", fileName, err)
+	}
+	c.fileName = fileName
+
+	var changed []string
+	if c.Command != command {
+		changed = append(changed, fmt.Sprintf("command '%s' instead of '%s'", command, c.Command))
+	}
+	for _, key := range paramKeys(params, c.Params) {
+		if params[key] != c.Params[key] {
+			changed = append(changed, fmt.Sprintf("%s '%s' instead of '%s'", key, params[key], c.Params[key]))
+		}
+	}
+	if len(changed) > 0 {
+		return nil, fmt.Errorf("can't resume from %s, the run has other options: %s", fileName, strings.Join(changed, ", "))
+	}
+	return c, nil
+}
+
+// paramKeys returns the keys of both option sets, sorted
+func paramKeys(a, b map[string]string) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]string{a, b} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// productDone records that a product is completed and saves the checkpoint
+func (c *checkpoint) productDone(id *int) error {
+	c.Done++
+	c.LastProductId = id
+	return c.save()
+}
+
+// save writes the checkpoint. It is replaced in a single step, so an interruption
+// never leaves a partly written checkpoint behind.
+func (c *checkpoint) save() error {
+	c.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.fileName + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.fileName)
+}
+
+// remove deletes the checkpoint of a run that completed
+func (c *checkpoint) remove() error {
+	err := os.Remove(c.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// describe returns a description of the progress for the user
+func (c *checkpoint) describe() string {
+	s := fmt.Sprintf("%d products done", c.Done)
+	if c.LastProductId != nil {
+		s += fmt.Sprintf(", the last one %d", *c.LastProductId)
+	}
+	failed := 0
+	for _, indexes := range c.Failed {
+		failed += len(indexes)
+	}
+	if failed > 0 {
+		s += fmt.Sprintf(", %d to retry", failed)
+	}
+	return s
+}
+
+// checkExportResume returns an error message if an export can't be resumed in the chosen format
+func checkExportResume() []string {
+	if !viper.GetBool("export.resume") {
+		return nil
+	}
+	if _, format := exportFile(); format != "ndjson" || viper.GetString("export.layout") == "dir" {
+		return []string{"--resume needs --format ndjson, the only format that is written while the export runs"}
+	}
+	return nil
+}
+
+// exportParams adds the options shared by all product exports to the params of a checkpoint
+func exportParams(store Store, params map[string]string) map[string]string {
+	params["store"] = store.Name()
+	params["namespace"] = viper.GetString("export.namespace")
+	params["include-product-info"] = strconv.FormatBool(viper.GetBool("export.include-product-info"))
+	params["include-variants"] = strconv.FormatBool(viper.GetBool("export.include-variants"))
+	return params
+}
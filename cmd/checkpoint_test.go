@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/viper"
+)
+
+func TestLoadCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "powereditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "output.ndjson.checkpoint")
+	params := map[string]string{"collection": "12", "namespace": "power-editor"}
+	id := 42
+	if err := newCheckpoint(fileName, "export collection", params).productDone(&id); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loadCheckpoint(fileName, "export collection", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Done != 1 || c.LastProductId == nil || *c.LastProductId != 42 {
+		t.Errorf("got %s", c.describe())
+	}
+
+	_, err = loadCheckpoint(fileName, "export collection", map[string]string{"collection": "13", "namespace": "power-editor"})
+	if err == nil || !strings.Contains(err.Error(), "collection '13' instead of '12'") {
+		t.Errorf("changed options weren't reported: %v", err)
+	}
+	if _, err = loadCheckpoint(fileName+".missing", "export collection", params); err == nil {
+		t.Error("a missing checkpoint wasn't reported")
+	}
+}
+
+func TestResumeStreamedExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "powereditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(f string) { outputFile = f }(outputFile)
+	outputFile = filepath.Join(dir, "output.json")
+	viper.Set("export.format", "ndjson")
+	defer viper.Set("export.format", "")
+	defer viper.Set("export.resume", false)
+
+	ids := []int{1, 2, 3}
+	products := []*shopify.Product{{Id: &ids[0]}, {Id: &ids[1]}, {Id: &ids[2]}}
+	params := map[string]string{"collection": "12"}
+
+	output := Output{Header: &Header{Store: "example.myshopify.com"}}
+	sink, err := newExportSink(&output, "export collection", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.addProduct(&ProductOutput{Id: &ids[0]}); err != nil {
+		t.Fatal(err)
+	}
+	// An interruption in the middle of the next line
+	sink.file.Write([]byte(`{"product":{"id":2,`))
+	sink.abort()
+
+	viper.Set("export.resume", true)
+	sink, err = newExportSink(&output, "export collection", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := sink.pending(products)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || *pending[0].Id != 2 {
+		t.Fatalf("got %d pending products", len(pending))
+	}
+	for _, p := range pending {
+		if err := sink.addProduct(&ProductOutput{Id: p.Id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "output.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := readNDJSON(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Products) != 3 || *got.Products[2].Id != 3 {
+		t.Errorf("got %d products", len(got.Products))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "output.ndjson.checkpoint")); !os.IsNotExist(err) {
+		t.Error("the checkpoint of a completed export wasn't removed")
+	}
+}
+
+func TestResumedImportChecksLastProduct(t *testing.T) {
+	last, other := 5, 6
+	im := &importer{checkpoint: &checkpoint{Done: 1, LastProductId: &last}, resumed: 1}
+	if err := im.importLine(&ndjsonRecord{Product: &ProductOutput{Id: &other}}, 0, 0); err == nil {
+		t.Error("a changed data file wasn't reported")
+	}
+	if err := im.importLine(&ndjsonRecord{Product: &ProductOutput{Id: &last}}, 0, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+// failingStore fails to write the metafields of a product once
+type failingStore struct {
+	Store
+	failId int
+	writes map[int]int
+}
+
+func (s *failingStore) SetMetafields(ref contentRef, namespace string, metafields []*shopify.Metafield) error {
+	s.writes[ref.id]++
+	if ref.id == s.failId && s.writes[ref.id] == 1 {
+		return errors.New("connection reset by peer")
+	}
+	return s.Store.SetMetafields(ref, namespace, metafields)
+}
+
+func TestResumedImportRetriesFailedProducts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "powereditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for key, value := range map[string]interface{}{
+		"import.namespace":       "power-editor",
+		"import.primary-key":     "handle",
+		"import.metafields-only": true,
+	} {
+		viper.Set(key, value)
+		defer viper.Set(key, nil)
+	}
+	defer viper.Set("import.resume", nil)
+
+	memory := newMemoryStore("test.myshopify.com")
+	var records []*ndjsonRecord
+	for _, handle := range []string{"ball", "mat", "roll"} {
+		p := memory.addProduct(&shopify.Product{Handle: strPtr(handle)})
+		records = append(records, &ndjsonRecord{Product: &ProductOutput{
+			Id: p.Id, Handle: strPtr(handle),
+			Fields: []*OutputField{{Key: strPtr("tabs"), Data: FieldData{{"a", "b"}}}},
+		}})
+	}
+	// A page that isn't in the store yet
+	page := &ndjsonRecord{Page: &PageOutput{Handle: strPtr("about-us")}}
+	store := &failingStore{Store: memory, failId: *records[1].Product.Id, writes: map[int]int{}}
+	dataFile := filepath.Join(dir, "output.json")
+
+	run := func() (*importer, error) {
+		im := &importer{store: store}
+		if err := im.keepCheckpoint(dataFile, "json"); err != nil {
+			t.Fatal(err)
+		}
+		for i, r := range records {
+			if err := im.importLine(r, i, len(records)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := im.importLine(page, 0, 1); err != nil {
+			t.Fatal(err)
+		}
+		return im, im.finish()
+	}
+
+	// The second product and the page fail, the checkpoint is kept to retry them
+	im, err := run()
+	if err == nil {
+		t.Error("the failed records weren't reported")
+	}
+	want := map[string][]int{kindProduct: {1}, kindPage: {0}}
+	if c := im.checkpoint; c.Done != 3 || !reflect.DeepEqual(c.Failed, want) {
+		t.Fatalf("got checkpoint %s, failed %v", c.describe(), c.Failed)
+	}
+	if _, err := os.Stat(checkpointFile(dataFile)); err != nil {
+		t.Fatalf("the checkpoint wasn't kept: %v", err)
+	}
+
+	// A resumed run imports the second product and the page only
+	memory.addContent(kindPage, 0, &content{Handle: strPtr("about-us")})
+	viper.Set("import.resume", true)
+	if _, err := run(); err != nil {
+		t.Error(err)
+	}
+	for i, r := range records {
+		want := 1
+		if i == 1 {
+			want = 2
+		}
+		if got := store.writes[*r.Product.Id]; got != want {
+			t.Errorf("product %d written %d times, want %d", i, got, want)
+		}
+	}
+	metafields, _ := memory.ListMetafields(contentRef{kind: kindProduct, id: *records[1].Product.Id}, "power-editor")
+	if len(metafields) != 1 {
+		t.Errorf("got %d metafields of the failed product", len(metafields))
+	}
+	if _, err := os.Stat(checkpointFile(dataFile)); !os.IsNotExist(err) {
+		t.Error("the checkpoint of a completed import wasn't removed")
+	}
+}
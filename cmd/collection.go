@@ -22,11 +22,8 @@ package cmd
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
@@ -47,92 +44,227 @@ var collectionCmd = &cobra.Command{
 
 	// Do all the error handling pre run
 	PreRunE: func(cmd *cobra.Command, args []string) error {
+		bindFlags(cmd, "export", "include-product-info", "include-variants", "concurrency", "resume")
 
 		// Check for required API credentials
-		errorMsg := checkGlobalRequiredFlags()
+		errorMsg := checkGlobalRequiredFlags("export")
+		errorMsg = append(errorMsg, checkExportResume()...)
 
 		// Check for required collection ID
 		if len(args) < 1 {
 			errorMsg = append(errorMsg, "a collection ID is required as an argument")
 		} else {
 			var err error
-			collectionId, err = strconv.Atoi(args[0])
+			if collectionId, err = strconv.Atoi(args[0]); err != nil {
+				errorMsg = append(errorMsg, fmt.Sprintf("'%s' is not a valid collection ID", args[0]))
+			}
+		}
+
+		return requiredFlagsError(errorMsg)
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := GetStore("export")
+
+		output := newOutput(store, viper.GetString("export.namespace"))
+		params := exportParams(store, map[string]string{"collection": strconv.Itoa(collectionId)})
+		sink, err := newExportSink(&output, "export collection", params)
+		if err != nil {
+			return err
+		}
+
+		if bulk, ok := store.(bulkExporter); ok {
+			fmt.Printf("== Exporting collection %d\n", collectionId)
+			err = bulk.ExportProducts(nil, collectionId, sink.resumeAt, sink.addProduct)
+		} else {
+			err = exportCollectionProducts(collectionId, store, sink)
+		}
+		if err != nil {
+			sink.abort()
 			return err
 		}
 
-		if len(errorMsg) == 1 {
-			return errors.New(errorMsg[0])
-		} else if len(errorMsg) > 0 {
-			return errors.New("\n - " + strings.Join(errorMsg, "\n - "))
+		// The collection itself carries power-editor content, too
+		collection, err := exportCollectionRecord(collectionId, store)
+		if err == nil {
+			err = sink.addCollection(collection)
+		}
+		if err != nil {
+			sink.abort()
+			return err
 		}
-		return nil
+		return sink.close()
 	},
+}
 
-	RunE: func(cmd *cobra.Command, args []string) error {
-		client := GetClient()
+// exportCollectionProducts exports the products of a collection one after the other
+func exportCollectionProducts(collectionId int, store Store, sink *exportSink) error {
+	s := spin.New("  \033[36m Scanning collection \033[m %s")
+	s.Set(spin.Spin1)
+	s.Start()
+	products, err := GetProductsByCollection(collectionId, store)
+	s.Stop()
+	if err != nil {
+		return err
+	}
+
+	if products, err = sink.pending(products); err != nil {
+		return err
+	}
+	return exportProducts(products, store, sink.addProduct)
+}
+
+// exportCollectionRecord returns the export data of a custom or smart collection
+func exportCollectionRecord(collectionId int, store Store) (*CollectionOutput, error) {
+	ref, collection, err := getCollection(collectionId, store)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := exportContent(ref, collection, store)
+	if err != nil {
+		return nil, err
+	}
+	return newCollectionOutput(ref.kind, collection, fields), nil
+}
+
+// exportProducts fetches the power-editor content of each product and hands it to emit
+// as soon as it is complete. Products without content are skipped. With --concurrency,
+// several products are fetched at once, but they are still handed to emit in order.
+// The export stops at the first product that can't be fetched.
+func exportProducts(products []*shopify.Product, store Store, emit func(*ProductOutput) error) error {
+	fetch := func(i int) (*ProductOutput, error) {
+		pout, err := buildProductOutput(products[i], store)
+		if err != nil {
+			return nil, fmt.Errorf("can't export product %d: %s", *products[i].Id, err)
+		}
+		return pout, nil
+	}
+	return fetchInOrder(len(products), viper.GetInt("export.concurrency"), fetch, func(i int, pout *ProductOutput) error {
+		if pout == nil {
+			return nil
+		}
+		return emit(pout)
+	})
+}
+
+// fetchInOrder calls fetch for the indexes 0 to count-1 from a pool of concurrent workers and
+// hands the results to emit in the order of their indexes. Workers don't get further ahead of
+// emit than twice their number. It stops at the first error returned by fetch or emit.
+func fetchInOrder(count int, concurrency int, fetch func(i int) (*ProductOutput, error), emit func(i int, pout *ProductOutput) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	type result struct {
+		pout *ProductOutput
+		err  error
+	}
+	results := make([]chan result, count)
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+
+	jobs := make(chan int)
+	window := make(chan bool, 2*concurrency)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < count; i++ {
+			select {
+			case window <- true:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			for i := range jobs {
+				pout, err := fetch(i)
+				results[i] <- result{pout, err}
+			}
+		}()
+	}
 
-		s := spin.New("  \033[36m Scanning collection \033[m %s")
+	for i := 0; i < count; i++ {
+		progress := fmt.Sprintf("%d of %d", i, count)
+		s := spin.New("  \033[36m Fetching product " + progress + "\033[m %s")
 		s.Set(spin.Spin1)
 		s.Start()
-		defer fmt.Println("== Exported to", outputFile)
-
-		products, err := GetProductsByCollection(collectionId, client)
+		r := <-results[i]
+		<-window
 		s.Stop()
-		if err != nil {
+
+		if r.err != nil {
+			return r.err
+		}
+		if err := emit(i, r.pout); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		var output Output
-		for i, product := range products {
-			progress := fmt.Sprintf("%d of %d", i, len(products))
-			s = spin.New("  \033[36m Fetching product " + progress + "\033[m %s")
-			s.Set(spin.Spin1)
-			s.Start()
-
-			metafields, _ := GetMetafieldsByProduct(*product.Id, viper.GetString("export.namespace"), client)
-
-			// Add this product if it has metafields that should be exported or if the default product information should be included
-			exportThisProduct := len(metafields) > 0 || viper.GetBool("export.include-product-info")
-
-			if exportThisProduct {
-				globalTitleTag, globalDescriptionTag := getSeoTagsByProduct(*product.Id, client)
-				outputFields := GenerateProductDataOutput(metafields)
-
-				// Fill in the output data
-				pout := &ProductOutput{
-					Id:                             product.Id,
-					Handle:                         product.Handle,
-					Title:                          product.Title,
-					MetafieldsGlobalTitleTag:       globalTitleTag,
-					MetafieldsGlobalDescriptionTag: globalDescriptionTag,
-					BodyHtml:                       product.BodyHtml,
-					Fields:                         outputFields,
-				}
-				output.Products = append(output.Products, pout)
-			}
-			s.Stop()
+// buildProductOutput returns the export data of a product or nil if there is nothing to export
+func buildProductOutput(product *shopify.Product, store Store) (*ProductOutput, error) {
+	metafields, err := GetMetafieldsByProduct(*product.Id, viper.GetString("export.namespace"), store)
+	if err != nil {
+		return nil, err
+	}
+
+	var variants []*VariantOutput
+	if viper.GetBool("export.include-variants") {
+		variants, err = exportVariants(*product.Id, product.Variants, viper.GetString("export.namespace"), false, store)
+		if err != nil {
+			return nil, fmt.Errorf("variants: %s", err)
 		}
+	}
 
-		writeToFile(output, outputFile)
-		return nil
-	},
+	if !hasExportContent(metafields, variants) {
+		return nil, nil
+	}
+
+	globalTitleTag, globalDescriptionTag, err := getSeoTagsByProduct(*product.Id, store)
+	if err != nil {
+		return nil, err
+	}
+	return productOutput(product, globalTitleTag, globalDescriptionTag, metafields, variants), nil
+}
+
+// hasExportContent tells if a product is exported. It is if it has metafields or variants
+// that should be exported or if the default product information should be included.
+func hasExportContent(metafields []*shopify.Metafield, variants []*VariantOutput) bool {
+	return len(metafields) > 0 || len(variants) > 0 || viper.GetBool("export.include-product-info")
+}
+
+// productOutput fills in the export data of a product
+func productOutput(product *shopify.Product, globalTitleTag *string, globalDescriptionTag *string, metafields []*shopify.Metafield, variants []*VariantOutput) *ProductOutput {
+	return &ProductOutput{
+		Id:                             product.Id,
+		Handle:                         product.Handle,
+		Title:                          product.Title,
+		MetafieldsGlobalTitleTag:       globalTitleTag,
+		MetafieldsGlobalDescriptionTag: globalDescriptionTag,
+		BodyHtml:                       product.BodyHtml,
+		Fields:                         GenerateProductDataOutput(metafields),
+		Variants:                       variants,
+	}
 }
 
 func init() {
 	// this is a subcommand to the "collection" command
 	collectionCmd.Flags().BoolP("include-product-info", "i", false, "Include product content (titles, descriptions) in export")
-	viper.BindPFlag("export.include-product-info", collectionCmd.Flags().Lookup("include-product-info"))
+	collectionCmd.Flags().BoolP("include-variants", "V", false, "Include the power-editor metafields of variants in export")
+	collectionCmd.Flags().Int("concurrency", 4, "the number of products fetched at once")
+	collectionCmd.Flags().Bool("resume", false, "continue an interrupted export from its checkpoint (needs --format ndjson)")
 	exportCmd.AddCommand(collectionCmd)
 }
 
-func writeToFile(thingsToWrite interface{}, fileName string) {
-	b, err := JSONMarshalIndent(thingsToWrite, "", "  ")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error parsing json %s", err)
-	}
-	ioutil.WriteFile(fileName, b, 0644)
-}
-
 // https://stackoverflow.com/questions/28595664/how-to-stop-json-marshal-from-escaping-and
 // Todo: Break out in own package
 func JSONMarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
@@ -157,48 +289,51 @@ func JSONMarshal(t interface{}) ([]byte, error) {
 }
 
 // Foo is my foo function
-func GetProductsByCollection(collectionId int, client *shopify.Client) ([]*shopify.Product, error) {
+func GetProductsByCollection(collectionId int, store Store) ([]*shopify.Product, error) {
 
 	// debug := godebug.Debug("output")
 	//spit := spew.ConfigState{Indent: " ", DisableCapacities: true, DisablePointerAddresses: true}
 
 	s := fmt.Sprintf("== Exporting collection %d", collectionId)
 	fmt.Println(s)
-	ctx := context.Background()
-
-	productFields := []string{"id", "handle"}
-	if viper.GetBool("export.include-product-info") {
-		productFields = append(productFields, "body_html", "title")
-		// debug("Export fields: %s", productFields)
-	}
-
 	opt := &shopify.ProductListOptions{
-		Fields:       productFields,
+		Fields:       exportProductFields(),
 		CollectionId: collectionId,
 	}
 
-	products, err := client.Products.AutoPagingList(ctx, opt)
+	products, err := store.ListProducts(opt)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Products.List() returned error: %v", err)
+		fmt.Fprintf(os.Stderr, "Products.List() returned error: %v\n", err)
 		return nil, err
 	}
 	if len(products) == 0 {
-		fmt.Fprintf(os.Stderr, "Products.List() returned no events")
+		fmt.Fprintf(os.Stderr, "Products.List() returned no events\n")
 	}
 	return products, nil
 }
 
-func GetMetafieldsByProduct(productId int, namespace string, client *shopify.Client) ([]*shopify.Metafield, error) {
-	ctx := context.Background()
-	opt := &shopify.MetafieldListOptions{Namespace: namespace, Fields: []string{"id", "key", "value"}}
-	metafields, _, err := client.Metafields.ListByProduct(ctx, productId, opt)
+// exportProductFields returns the product properties that need to be fetched for an export
+func exportProductFields() []string {
+	productFields := []string{"id", "handle"}
+	if viper.GetBool("export.include-product-info") {
+		productFields = append(productFields, "body_html", "title")
+		// debug("Export fields: %s", productFields)
+	}
+	if viper.GetBool("export.include-variants") {
+		productFields = append(productFields, "variants")
+	}
+	return productFields
+}
+
+func GetMetafieldsByProduct(productId int, namespace string, store Store) ([]*shopify.Metafield, error) {
+	metafields, err := store.ListMetafields(contentRef{kind: kindProduct, id: productId}, namespace)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Metafields.List() returned error: %v", err)
+		fmt.Fprintf(os.Stderr, "Metafields.List() returned error: %v\n", err)
 		return nil, err
 	}
 	if len(metafields) == 0 {
-		fmt.Fprintf(os.Stderr, "Metafields.List() returned no events")
+		fmt.Fprintf(os.Stderr, "Metafields.List() returned no events\n")
 	}
 	return metafields, nil
 }
@@ -207,25 +342,23 @@ func GenerateProductDataOutput(fields []*shopify.Metafield) (data []*OutputField
 
 	for _, field := range fields {
 		out := &OutputField{
-			Data: make(map[string]map[string]string),
-			Key:  field.Key,
-			Id:   field.Id,
+			Key: field.Key,
+			Id:  field.Id,
 		}
 		rows := strings.Split(*field.Value, rowSeparator)
-		for i, row := range rows {
-			cols := strings.Split(row, colSeparator)
-			out.Data[strconv.Itoa(i)] = make(map[string]string)
-			for j, col := range cols {
-				out.Data[strconv.Itoa(i)][strconv.Itoa(j)] = col
-			}
+		for _, row := range rows {
+			out.Data = append(out.Data, strings.Split(row, colSeparator))
 		}
 		data = append(data, out)
 	}
 	return
 }
 
-func getSeoTagsByProduct(productID int, client *shopify.Client) (globalTitleTag *string, globalDescriptionTag *string) {
-	globalMetafields, _ := GetMetafieldsByProduct(productID, "global", client)
+func getSeoTagsByProduct(productID int, store Store) (globalTitleTag *string, globalDescriptionTag *string, err error) {
+	globalMetafields, err := GetMetafieldsByProduct(productID, "global", store)
+	if err != nil {
+		return nil, nil, err
+	}
 	for _, field := range globalMetafields {
 		if *field.Key == "title_tag" {
 			globalTitleTag = field.Value
@@ -234,5 +367,5 @@ func getSeoTagsByProduct(productID int, client *shopify.Client) (globalTitleTag
 			globalDescriptionTag = field.Value
 		}
 	}
-	return globalTitleTag, globalDescriptionTag
+	return globalTitleTag, globalDescriptionTag, nil
 }
@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -76,3 +78,39 @@ func TestJsonParsing(t *testing.T) {
 		t.Errorf("Error generating output")
 	}
 }
+
+func TestFieldDataRoundTrip(t *testing.T) {
+	var rows []string
+	for i := 0; i < 12; i++ {
+		rows = append(rows, fmt.Sprintf("row %d<!--|col|-->a<!--|col|-->b<!--|col|-->c<!--|col|-->d<!--|col|-->e<!--|col|-->f<!--|col|-->g<!--|col|-->h<!--|col|-->i<!--|col|-->j<!--|col|-->k", i))
+	}
+	value := strings.Join(rows, "<!--|row|-->")
+
+	out := GenerateProductDataOutput([]*shopify.Metafield{{Key: strPtr("tabs"), Value: &value}})
+	b, err := JSONMarshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in []*OutputField
+	if err := json.Unmarshal(b, &in); err != nil {
+		t.Fatal(err)
+	}
+	if got := metafieldValue(in[0]); got != value {
+		t.Errorf("round trip changed the value:\n got %q\nwant %q", got, value)
+	}
+}
+
+func TestFieldDataV1(t *testing.T) {
+	var field OutputField
+	data := []byte(`{"key": "tabs", "data": {
+		"10": {"0": "k"}, "2": {"0": "c"}, "0": {"10": "a10", "0": "a0", "9": "a9", "1": "a1"}, "1": {"0": "b"},
+		"3": {"0": "d"}, "4": {"0": "e"}, "5": {"0": "f"}, "6": {"0": "g"}, "7": {"0": "h"}, "8": {"0": "i"}, "9": {"0": "j"}
+	}}`)
+	if err := json.Unmarshal(data, &field); err != nil {
+		t.Fatal(err)
+	}
+	want := "a0<!--|col|-->a1<!--|col|-->a9<!--|col|-->a10<!--|row|-->b<!--|row|-->c<!--|row|-->d<!--|row|-->e<!--|row|-->f<!--|row|-->g<!--|row|-->h<!--|row|-->i<!--|row|-->j<!--|row|-->k"
+	if got := metafieldValue(&field); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+)
+
+// utf8BOM makes spreadsheet applications read CSV files as UTF-8
+const utf8BOM = "\xef\xbb\xbf"
+
+// writeCSV writes a data file as CSV. The header of the data file comes first, as a row for
+// each of its values. Every record starts with a row holding its content, followed by a row
+// for each row of its fields.
+func writeCSV(w io.Writer, output *Output) error {
+	records := flattenOutput(output)
+	// The header values need the first numbered column
+	width := 1
+	for _, r := range records {
+		if n := fieldWidth(r.fields); n > width {
+			width = n
+		}
+	}
+
+	if _, err := io.WriteString(w, utf8BOM); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	header := tableHeader(width, identityColumns, contentColumns, fieldColumns)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if output.Header != nil {
+		if err := cw.WriteAll(headerRows(output.Header, header)); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range records {
+		identity := identityCells(r)
+		line := append(append(append([]string{}, identity...), contentCells(r)...), "", "", "")
+		if err := cw.Write(pad(line, len(header))); err != nil {
+			return err
+		}
+
+		for _, f := range r.fields {
+			for _, cells := range fieldCells(f) {
+				line := append(append(append([]string{}, identity...), make([]string, len(contentColumns))...), cells...)
+				if err := cw.Write(pad(line, len(header))); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// readCSV reads a data file written by writeCSV
+func readCSV(r io.Reader) (*Output, error) {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, []byte(utf8BOM)) {
+		br.Discard(len(utf8BOM))
+	}
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = -1
+	table, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTableReader()
+	if err := t.add("CSV", table); err != nil {
+		return nil, err
+	}
+	return t.output()
+}
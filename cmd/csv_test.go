@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVSortsRows(t *testing.T) {
+	file := "kind,handle,field,row,0,1\n" +
+		"product,blackroll-mini,tabs,10,k,\n" +
+		"product,blackroll-mini,tabs,2,c,d\n" +
+		"product,blackroll-mini,tabs,0,a,b\n"
+	data, err := readCSV(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FieldData{{"a", "b"}, {"c", "d"}, {"k"}}
+	if got := data.Products[0].Fields[0].Data; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReadCSVKeepsCellsBeyondCols(t *testing.T) {
+	file := "kind,handle,field,row,cols,0,1,2\n" +
+		"product,blackroll-mini,tabs,0,1,a,b,\n" +
+		"product,blackroll-mini,tabs,1,2,c,,\n" +
+		"product,blackroll-mini,tabs,2,3,d,,\n"
+	data, err := readCSV(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FieldData{{"a", "b"}, {"c", ""}, {"d", "", ""}}
+	if got := data.Products[0].Fields[0].Data; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -20,7 +20,12 @@
 
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
 
 // exportCmd represents the collection command
 var exportCmd = &cobra.Command{
@@ -29,5 +34,28 @@ var exportCmd = &cobra.Command{
 }
 
 func init() {
+	exportCmd.PersistentFlags().String("format", "", "the file format of the export: "+strings.Join(formatNames(), ", ")+" (default is told by the extension of the output file)")
+	exportCmd.PersistentFlags().String("source-language", "en", "the language of the exported content, written to translation files (xliff, po)")
+	exportCmd.PersistentFlags().String("target-language", "", "the language the content is translated into, written to translation files (xliff, po)")
+	exportCmd.PersistentFlags().String("layout", "file", "write a single file, or a directory with a file per record (dir)")
+	exportCmd.PersistentFlags().Bool("split-html", false, "with the dir layout, write HTML descriptions and cells to files of their own")
+	viper.BindPFlag("export.format", exportCmd.PersistentFlags().Lookup("format"))
+	viper.BindPFlag("export.layout", exportCmd.PersistentFlags().Lookup("layout"))
+	viper.BindPFlag("export.split-html", exportCmd.PersistentFlags().Lookup("split-html"))
+	viper.BindPFlag("export.source-language", exportCmd.PersistentFlags().Lookup("source-language"))
+	viper.BindPFlag("export.target-language", exportCmd.PersistentFlags().Lookup("target-language"))
 	RootCmd.AddCommand(exportCmd)
 }
+
+// exportContent adds the SEO tags of a resource to c and returns its power-editor fields
+func exportContent(ref contentRef, c *content, store Store) ([]*OutputField, error) {
+	metafields, err := store.ListMetafields(ref, viper.GetString("export.namespace"))
+	if err != nil {
+		return nil, err
+	}
+	c.MetafieldsGlobalTitleTag, c.MetafieldsGlobalDescriptionTag, err = getSeoTags(ref, store)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateProductDataOutput(metafields), nil
+}
@@ -0,0 +1,573 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/cobra"
+)
+
+// The fake shop serves the product, collection and metafield endpoints of the REST Admin API
+// from a memoryStore. It pages lists and limits API calls like a real store, so export and
+// import can be tried out, and tested end to end, without one.
+
+// fakeShopBucketSize is the number of API calls a fake shop accepts in a burst
+const fakeShopBucketSize = 40
+
+// fakeShopLeakRate is the number of API calls per second that leak out of a fake shop's bucket
+const fakeShopLeakRate = 2
+
+// devCmd groups the commands that help developing and testing powereditor-cli
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "Tools for developing and testing powereditor-cli",
+}
+
+// fakeShopCmd represents the "dev fake-shop" command
+var fakeShopCmd = &cobra.Command{
+	Use:   "fake-shop <seed>",
+	Short: "Serve the products, collections and metafields of a seed file like the Shopify Admin API",
+	Long: `Serve the products, collections and metafields of a seed file like the Shopify Admin API.
+Changes are kept in memory until the server is stopped. Point the other commands at the
+fake shop with --api-url, e.g.
+
+  powereditor-cli dev fake-shop seed.json
+  powereditor-cli export collection 7 -k any -p any -s fake --api-url http://localhost:8080/admin/`,
+
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("path to seed file required as an argument")
+		}
+		return nil
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		shop, err := loadFakeShop(args[0])
+		if err != nil {
+			return err
+		}
+		addr, _ := cmd.Flags().GetString("addr")
+		fmt.Printf("== Serving %s at http://%s/admin/\n", shop.store.Name(), addr)
+		return http.ListenAndServe(addr, shop)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(devCmd)
+	devCmd.AddCommand(fakeShopCmd)
+	fakeShopCmd.Flags().String("addr", "localhost:8080", "the address the fake shop listens on")
+}
+
+// fakeShopSeed is the content of a seed file. Metafields are attached to the products,
+// variants and collections they are listed with, the top level ones belong to the shop.
+type fakeShopSeed struct {
+	Shop              string                `json:"shop"`
+	Products          []*fakeSeedProduct    `json:"products"`
+	CustomCollections []*fakeSeedCollection `json:"custom_collections"`
+	SmartCollections  []*fakeSeedCollection `json:"smart_collections"`
+	Metafields        []*shopify.Metafield  `json:"metafields"`
+}
+
+type fakeSeedProduct struct {
+	shopify.Product
+	Variants []*fakeSeedVariant `json:"variants,omitempty"`
+}
+
+type fakeSeedVariant struct {
+	shopify.Variant
+	Metafields []*shopify.Metafield `json:"metafields,omitempty"`
+}
+
+type fakeSeedCollection struct {
+	content
+	ProductIds []int                `json:"product_ids,omitempty"`
+	Metafields []*shopify.Metafield `json:"metafields,omitempty"`
+}
+
+// fakeShop is an http.Handler that serves the REST Admin API of a memoryStore
+type fakeShop struct {
+	store *memoryStore
+
+	mu sync.Mutex
+	// level is the number of calls in the bucket at the time of updated
+	level   float64
+	updated time.Time
+	now     func() time.Time
+}
+
+func newFakeShop(store *memoryStore) *fakeShop {
+	return &fakeShop{store: store, now: time.Now}
+}
+
+// loadFakeShop returns a fake shop with the content of a seed file
+func loadFakeShop(seedFile string) (*fakeShop, error) {
+	f, err := os.Open(seedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var seed fakeShopSeed
+	if err := json.NewDecoder(f).Decode(&seed); err != nil {
+		return nil, fmt.Errorf("Can't read seed file %s: %s", seedFile, err)
+	}
+	return newFakeShop(seed.memoryStore()), nil
+}
+
+// memoryStore returns a store with the resources of the seed
+func (seed *fakeShopSeed) memoryStore() *memoryStore {
+	name := seed.Shop
+	if name == "" {
+		name = "fake.myshopify.com"
+	}
+	store := newMemoryStore(name)
+	for _, sp := range seed.Products {
+		p := sp.Product
+		p.Variants = nil
+		for _, sv := range sp.Variants {
+			v := sv.Variant
+			p.Variants = append(p.Variants, &v)
+		}
+		metafields := p.Metafields
+		p.Metafields = nil
+		store.addProduct(&p)
+		for _, m := range metafields {
+			store.attachMetafield(contentRef{kind: kindProduct, id: *p.Id}, m)
+		}
+		for i, sv := range sp.Variants {
+			for _, m := range sv.Metafields {
+				store.attachMetafield(contentRef{kind: kindVariant, id: *p.Variants[i].Id, parentId: *p.Id}, m)
+			}
+		}
+	}
+	for kind, collections := range map[string][]*fakeSeedCollection{kindCustomCollection: seed.CustomCollections, kindSmartCollection: seed.SmartCollections} {
+		for _, sc := range collections {
+			c := store.addContent(kind, 0, &sc.content)
+			store.addToCollection(*c.Id, sc.ProductIds...)
+			for _, m := range sc.Metafields {
+				store.attachMetafield(contentRef{kind: kind, id: *c.Id}, m)
+			}
+		}
+	}
+	for _, m := range seed.Metafields {
+		store.attachMetafield(contentRef{kind: kindShop}, m)
+	}
+	return store
+}
+
+// fakeShopError is an error response of the fake shop
+type fakeShopError struct {
+	status  int
+	message string
+}
+
+func (e *fakeShopError) Error() string {
+	return e.message
+}
+
+func fakeNotFound() error {
+	return &fakeShopError{http.StatusNotFound, "Not Found"}
+}
+
+func fakeBadRequest(format string, a ...interface{}) error {
+	return &fakeShopError{http.StatusBadRequest, fmt.Sprintf(format, a...)}
+}
+
+func (s *fakeShop) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	level, ok := s.call()
+	w.Header().Set(callLimitHeader, fmt.Sprintf("%d/%d", level, fakeShopBucketSize))
+	if !ok {
+		w.Header().Set("Retry-After", "2.0")
+		writeFakeShopError(w, &fakeShopError{http.StatusTooManyRequests, "Exceeded 2 calls per second for api client. Reduce request rates to resume uninterrupted service."})
+		return
+	}
+
+	status := http.StatusOK
+	v, err := s.route(req)
+	if err != nil {
+		writeFakeShopError(w, err)
+		return
+	}
+	if req.Method == "POST" {
+		status = http.StatusCreated
+	}
+	b, err := JSONMarshal(v)
+	if err != nil {
+		writeFakeShopError(w, err)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeFakeShopError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*fakeShopError); ok {
+		status = e.status
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"errors": err.Error()})
+}
+
+// call adds an API call to the leaky bucket. It returns the new number of calls in the
+// bucket and false if the bucket was full.
+func (s *fakeShop) call() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.level -= now.Sub(s.updated).Seconds() * fakeShopLeakRate
+	if s.level < 0 {
+		s.level = 0
+	}
+	s.updated = now
+	if s.level+1 > fakeShopBucketSize {
+		return int(s.level), false
+	}
+	s.level++
+	return int(s.level + 0.5), true
+}
+
+// route dispatches a request by its path below /admin/, with or without an API version
+func (s *fakeShop) route(req *http.Request) (interface{}, error) {
+	path := strings.TrimPrefix(req.URL.Path, "/admin/")
+	if path == req.URL.Path || !strings.HasSuffix(path, ".json") {
+		return nil, fakeNotFound()
+	}
+	segments := strings.Split(strings.TrimSuffix(path, ".json"), "/")
+	if len(segments) > 2 && segments[0] == "api" {
+		segments = segments[2:]
+	}
+
+	n := len(segments)
+	switch {
+	case segments[n-1] == "metafields":
+		return s.metafields(req, segments[:n-1])
+	case n >= 2 && segments[n-2] == "metafields":
+		return s.metafield(req, segments[:n-2], segments[n-1])
+	case n == 1 && segments[0] == "products":
+		return s.listProducts(req)
+	case n == 2 && segments[0] == "products" && segments[1] == "count":
+		products, err := s.products(req)
+		return map[string]int{"count": len(products)}, err
+	case n == 1:
+		return s.listContent(req, strings.TrimSuffix(segments[0], "s"), 0)
+	case n == 3 && segments[0] == "blogs" && segments[2] == "articles":
+		blogId, err := strconv.Atoi(segments[1])
+		if err != nil {
+			return nil, fakeNotFound()
+		}
+		return s.listContent(req, kindArticle, blogId)
+	}
+	ref, ok := fakeShopRef(segments)
+	if !ok || ref.kind == kindVariant || !s.store.exists(ref) {
+		return nil, fakeNotFound()
+	}
+	return s.content(req, ref)
+}
+
+// fakeShopRef returns the resource at the path segments, e.g. products/5/variants/6
+func fakeShopRef(segments []string) (contentRef, bool) {
+	if len(segments) == 0 {
+		return contentRef{kind: kindShop}, true
+	}
+	var ids []int
+	for i := 1; i < len(segments); i += 2 {
+		id, err := strconv.Atoi(segments[i])
+		if err != nil {
+			return contentRef{}, false
+		}
+		ids = append(ids, id)
+	}
+	switch {
+	case len(segments) == 2 && segments[0] == "collections":
+		return contentRef{kind: kindCustomCollection, id: ids[0]}, true
+	case len(segments) == 2:
+		return contentRef{kind: strings.TrimSuffix(segments[0], "s"), id: ids[0]}, true
+	case len(segments) == 4 && segments[0] == "products" && segments[2] == "variants":
+		return contentRef{kind: kindVariant, id: ids[1], parentId: ids[0]}, true
+	case len(segments) == 4 && segments[0] == "blogs" && segments[2] == "articles":
+		return contentRef{kind: kindArticle, id: ids[1], parentId: ids[0]}, true
+	}
+	return contentRef{}, false
+}
+
+// pageBounds returns the part of a list of n items that the limit and page parameters ask for
+func pageBounds(req *http.Request, n int) (start int, end int, err error) {
+	limit, p := 50, 1
+	q := req.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 250 {
+			return 0, 0, fakeBadRequest("limit must be between 1 and 250")
+		}
+	}
+	if v := q.Get("page"); v != "" {
+		if p, err = strconv.Atoi(v); err != nil || p < 1 {
+			return 0, 0, fakeBadRequest("page must be a positive number")
+		}
+	}
+	start, end = (p-1)*limit, p*limit
+	if start > n {
+		start = n
+	}
+	if end > n {
+		end = n
+	}
+	return start, end, nil
+}
+
+// queryList returns the comma separated values of a query parameter
+func queryList(req *http.Request, name string) []string {
+	if v := req.URL.Query().Get(name); v != "" {
+		return strings.Split(v, ",")
+	}
+	return nil
+}
+
+// products returns the products matching the query parameters of a list request
+func (s *fakeShop) products(req *http.Request) ([]*shopify.Product, error) {
+	q := req.URL.Query()
+	opt := &shopify.ProductListOptions{
+		Title:       q.Get("title"),
+		Vendor:      q.Get("vendor"),
+		Handle:      q.Get("handle"),
+		ProductType: q.Get("product_type"),
+		Fields:      queryList(req, "fields"),
+	}
+	for _, id := range queryList(req, "ids") {
+		i, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fakeBadRequest("ids must be numbers")
+		}
+		opt.Ids = append(opt.Ids, i)
+	}
+	for name, v := range map[string]*int{"since_id": &opt.SinceId, "collection_id": &opt.CollectionId} {
+		if value := q.Get(name); value != "" {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fakeBadRequest("%s must be a number", name)
+			}
+			*v = i
+		}
+	}
+	if v := q.Get("updated_at_min"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fakeBadRequest("updated_at_min must be a date")
+		}
+		opt.UpdatedAtMin = t
+	}
+	return s.store.ListProducts(opt)
+}
+
+func (s *fakeShop) listProducts(req *http.Request) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, fakeNotFound()
+	}
+	products, err := s.products(req)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := pageBounds(req, len(products))
+	return map[string][]*shopify.Product{"products": products[start:end]}, err
+}
+
+func (s *fakeShop) listContent(req *http.Request, kind string, parentId int) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, fakeNotFound()
+	}
+	opt := &contentListOptions{Handle: req.URL.Query().Get("handle"), Title: req.URL.Query().Get("title"), Fields: queryList(req, "fields")}
+	list, err := s.store.ListContent(kind, parentId, opt)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := pageBounds(req, len(list))
+	return map[string][]*content{kind + "s": list[start:end]}, err
+}
+
+// content gets or edits a product, collection, page, blog or article
+func (s *fakeShop) content(req *http.Request, ref contentRef) (interface{}, error) {
+	switch req.Method {
+	case "GET":
+		if ref.kind == kindProduct {
+			products, err := s.store.ListProducts(&shopify.ProductListOptions{Ids: []int{ref.id}, Fields: queryList(req, "fields")})
+			if err != nil || len(products) == 0 {
+				return nil, fakeNotFound()
+			}
+			return map[string]*shopify.Product{kindProduct: products[0]}, nil
+		}
+		c, err := s.store.GetContent(ref)
+		if err != nil {
+			return nil, fakeNotFound()
+		}
+		return map[string]*content{ref.kind: c}, nil
+	case "PUT":
+		var body map[string]*content
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body[ref.kind] == nil {
+			return nil, fakeBadRequest("expected a %s", ref.kind)
+		}
+		if err := s.store.EditContent(ref, body[ref.kind]); err != nil {
+			return nil, err
+		}
+		return s.content(&http.Request{Method: "GET", URL: req.URL}, ref)
+	}
+	return nil, fakeNotFound()
+}
+
+// metafields lists or creates the metafields of the resource at the owner path segments
+func (s *fakeShop) metafields(req *http.Request, owner []string) (interface{}, error) {
+	ref, ok := fakeShopRef(owner)
+	if !ok || !s.store.exists(ref) {
+		return nil, fakeNotFound()
+	}
+	switch req.Method {
+	case "GET":
+		list, err := s.store.ListMetafields(ref, req.URL.Query().Get("namespace"))
+		if err != nil {
+			return nil, err
+		}
+		if v := req.URL.Query().Get("since_id"); v != "" {
+			sinceId, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fakeBadRequest("since_id must be a number")
+			}
+			var after []*shopify.Metafield
+			for _, m := range list {
+				if *m.Id > sinceId {
+					after = append(after, m)
+				}
+			}
+			list = after
+		}
+		if keys := queryList(req, "fields"); keys != nil {
+			for i, m := range list {
+				if err := copyFields(m, &list[i], keys); err != nil {
+					return nil, err
+				}
+			}
+		}
+		start, end, err := pageBounds(req, len(list))
+		return map[string][]*shopify.Metafield{"metafields": list[start:end]}, err
+	case "POST":
+		m, err := decodeMetafield(req)
+		if err != nil {
+			return nil, err
+		}
+		if m.Namespace == nil || m.Key == nil || m.Value == nil {
+			return nil, &fakeShopError{http.StatusUnprocessableEntity, "namespace, key and value are required"}
+		}
+		existing, err := s.store.ListMetafields(ref, *m.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range existing {
+			if *e.Key == *m.Key {
+				return nil, &fakeShopError{http.StatusUnprocessableEntity, "key must be unique within this namespace"}
+			}
+		}
+		created := &shopify.Metafield{Key: m.Key, Value: m.Value, ValueType: m.ValueType}
+		if err := s.store.SetMetafields(ref, *m.Namespace, []*shopify.Metafield{created}); err != nil {
+			return nil, err
+		}
+		list, err := s.store.ListMetafields(ref, *m.Namespace)
+		for _, m := range list {
+			if *m.Key == *created.Key {
+				return &metafieldContainer{Metafield: m}, err
+			}
+		}
+		return nil, err
+	}
+	return nil, fakeNotFound()
+}
+
+// metafield updates or deletes a metafield of the resource at the owner path segments
+func (s *fakeShop) metafield(req *http.Request, owner []string, idSegment string) (interface{}, error) {
+	id, err := strconv.Atoi(idSegment)
+	if err != nil {
+		return nil, fakeNotFound()
+	}
+	ref, ok := fakeShopRef(owner)
+	if !ok || !s.store.exists(ref) {
+		return nil, fakeNotFound()
+	}
+	switch req.Method {
+	case "PUT":
+		m, err := decodeMetafield(req)
+		if err != nil {
+			return nil, err
+		}
+		if m.Value == nil {
+			return nil, &fakeShopError{http.StatusUnprocessableEntity, "value is required"}
+		}
+		existing, err := s.store.ListMetafields(ref, "")
+		if err != nil {
+			return nil, err
+		}
+		current := findMetafield(existing, id)
+		if current == nil {
+			return nil, fakeNotFound()
+		}
+		current.Value = m.Value
+		if err := s.store.SetMetafields(ref, *current.Namespace, []*shopify.Metafield{current}); err != nil {
+			return nil, err
+		}
+		return &metafieldContainer{Metafield: current}, nil
+	case "DELETE":
+		// Metafields can be deleted without their owner, at /admin/metafields/<id>.json
+		if ref.kind != kindShop {
+			existing, err := s.store.ListMetafields(ref, "")
+			if err != nil {
+				return nil, err
+			}
+			if findMetafield(existing, id) == nil {
+				return nil, fakeNotFound()
+			}
+		}
+		if !s.store.removeMetafield(id) {
+			return nil, fakeNotFound()
+		}
+		return struct{}{}, nil
+	}
+	return nil, fakeNotFound()
+}
+
+// decodeMetafield reads the metafield in the body of a request
+func decodeMetafield(req *http.Request) (*shopify.Metafield, error) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	var body metafieldContainer
+	if err := json.Unmarshal(b, &body); err != nil || body.Metafield == nil {
+		return nil, fakeBadRequest("expected a metafield")
+	}
+	return body.Metafield, nil
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/viper"
+)
+
+// newFakeShopServer serves the content of a seed file like the Admin API of a store
+func newFakeShopServer(t *testing.T, seedFile string) (*httptest.Server, *fakeShop) {
+	shop, err := loadFakeShop(seedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(shop), shop
+}
+
+// useFakeShop points the credentials of a config section ("export" or "import") at a fake
+// shop server. The returned function restores them.
+func useFakeShop(section string, server *httptest.Server) func() {
+	settings := map[string]string{
+		"key":      "key",
+		"password": "password",
+		"store":    section + "-test",
+		"api-url":  server.URL + "/admin/",
+	}
+	for name, value := range settings {
+		viper.Set(section+"."+name, value)
+	}
+	return func() {
+		for name := range settings {
+			viper.Set(section+"."+name, nil)
+		}
+	}
+}
+
+func TestFakeShopPaging(t *testing.T) {
+	server, _ := newFakeShopServer(t, "testdata/fakeshop.json")
+	defer server.Close()
+
+	get := func(path string, v interface{}) *http.Response {
+		resp, err := http.Get(server.URL + "/admin/" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp
+	}
+
+	var list struct {
+		Products []struct {
+			Id     int    `json:"id"`
+			Handle string `json:"handle"`
+			Title  string `json:"title"`
+		} `json:"products"`
+	}
+	resp := get("products.json?limit=2&page=2&fields=id,handle", &list)
+	if len(list.Products) != 1 || list.Products[0].Handle != "blackroll-mat" || list.Products[0].Title != "" {
+		t.Errorf("got page %+v", list.Products)
+	}
+	if got := resp.Header.Get(callLimitHeader); got != "1/40" {
+		t.Errorf("got call limit %s", got)
+	}
+
+	var count struct {
+		Count int `json:"count"`
+	}
+	get("products/count.json?collection_id=7", &count)
+	if count.Count != 2 {
+		t.Errorf("got %d products in the collection", count.Count)
+	}
+	if resp := get("products.json?limit=251", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d for a limit above 250", resp.StatusCode)
+	}
+	if resp := get("products/1.json", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d for a missing product", resp.StatusCode)
+	}
+}
+
+func TestListMetafieldsPages(t *testing.T) {
+	server, shop := newFakeShopServer(t, "testdata/fakeshop.json")
+	defer server.Close()
+	product := contentRef{kind: kindProduct, id: 3894060097}
+	before, _ := shop.store.ListMetafields(product, "power-editor")
+	for i := 0; i < 300; i++ {
+		shop.store.addMetafield(product, "power-editor", fmt.Sprintf("key-%d", i), "value")
+	}
+
+	store := &restStore{client: NewClient(StoreCredentials{Key: "key", Password: "password", Store: "test", APIURL: server.URL + "/admin/"})}
+	got, err := store.ListMetafields(product, "power-editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(before) + 300; len(got) != want {
+		t.Errorf("got %d metafields, want %d", len(got), want)
+	}
+}
+
+func TestFakeShopCallLimit(t *testing.T) {
+	server, shop := newFakeShopServer(t, "testdata/fakeshop.json")
+	defer server.Close()
+	now := time.Now()
+	shop.now = func() time.Time { return now }
+
+	for i := 0; i < fakeShopBucketSize; i++ {
+		resp, err := http.Get(server.URL + "/admin/products/count.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Get(server.URL + "/admin/products/count.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("got status %d with a full bucket", resp.StatusCode)
+	}
+
+	// The bucket leaks two calls per second
+	now = now.Add(time.Second)
+	resp, err = http.Get(server.URL + "/admin/products/count.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(callLimitHeader) != "39/40" {
+		t.Errorf("got status %d and call limit %s after a second", resp.StatusCode, resp.Header.Get(callLimitHeader))
+	}
+}
+
+func TestFakeShopRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the API client sends two requests per second at most")
+	}
+	dir, err := ioutil.TempDir("", "powereditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source, _ := newFakeShopServer(t, "testdata/fakeshop.json")
+	defer source.Close()
+	destination, shop := newFakeShopServer(t, "testdata/fakeshop.json")
+	defer destination.Close()
+	defer useFakeShop("export", source)()
+	defer useFakeShop("import", destination)()
+
+	// The destination has an outdated and a stale metafield
+	product := contentRef{kind: kindProduct, id: 3894060097}
+	metafields, _ := shop.store.ListMetafields(product, "power-editor")
+	for _, m := range metafields {
+		if *m.Key == "accordion" {
+			shop.store.SetMetafields(product, "power-editor", []*shopify.Metafield{{Id: m.Id, Key: m.Key, Value: strPtr("old")}})
+		}
+	}
+	shop.store.addMetafield(product, "power-editor", "stale", "gone")
+
+	for key, value := range map[string]interface{}{
+		"export.include-variants": true,
+		"import.primary-key":      "handle",
+		"import.prune":            true,
+		"import.no-backup":        true,
+	} {
+		viper.Set(key, value)
+		defer viper.Set(key, nil)
+	}
+	defer func(f string) { outputFile = f }(outputFile)
+	outputFile = filepath.Join(dir, "output.json")
+
+	if err := collectionCmd.PreRunE(collectionCmd, []string{"7"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := collectionCmd.RunE(collectionCmd, []string{"7"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importCmd.PreRunE(importCmd, []string{outputFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importCmd.RunE(importCmd, []string{outputFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := shop.store.ListMetafields(product, "power-editor")
+	values := make(map[string]string)
+	for _, m := range got {
+		values[*m.Key] = *m.Value
+	}
+	if len(values) != 2 || values["video"] != "XGBQkxcM8DI" || values["accordion"] != "Maße"+colSeparator+"<ul><li>45 cm x 15 cm</li></ul>"+rowSeparator+"Material"+colSeparator+"EPP" {
+		t.Errorf("got metafields %v", values)
+	}
+	variant, _ := shop.store.ListMetafields(contentRef{kind: kindVariant, id: 11001, parentId: 3894060097}, "power-editor")
+	if len(variant) != 1 || *variant[0].Value != "45 cm" {
+		t.Errorf("got variant metafields %v", variant)
+	}
+	collection, _ := shop.store.ListMetafields(contentRef{kind: kindCustomCollection, id: 7}, "power-editor")
+	if len(collection) != 1 || *collection[0].Key != "banner" {
+		t.Errorf("got collection metafields %v", collection)
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// dataFormat reads and writes data files in one file format
+type dataFormat struct {
+	read  func(r io.Reader) (*Output, error)
+	write func(w io.Writer, output *Output) error
+}
+
+// dataFormats are the supported file formats by name (and file extension)
+var dataFormats = map[string]*dataFormat{
+	"json": {read: readJSON, write: writeJSON},
+	// NDJSON has a line per record and is written while an export is still running
+	"ndjson": {read: readNDJSON, write: writeNDJSON},
+	"yaml":   {read: readYAML, write: writeYAML},
+	"toml":   {read: readTOML, write: writeTOML},
+	"csv":    {read: readCSV, write: writeCSV},
+	"xlsx":   {read: readXLSX, write: writeXLSX},
+	// XLIFF 1.2 is understood by more translation tools than 2.0
+	"xliff":  {read: readXLIFF, write: writeXLIFF12},
+	"xliff2": {read: readXLIFF, write: writeXLIFF20},
+	"po":     {read: readPO, write: writePOFile},
+	"pot":    {read: readPO, write: writePOT},
+}
+
+// formatExtensions are file extensions that differ from the name of their format
+var formatExtensions = map[string]string{
+	"xlf": "xliff",
+	"yml": "yaml",
+}
+
+// formatNames returns the names of the supported file formats
+func formatNames() []string {
+	var names []string
+	for name := range dataFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkFormat returns an error message if the format or layout set in a config section is not supported
+func checkFormat(section string) []string {
+	var errorMsg []string
+	name := viper.GetString(section + ".format")
+	if name != "" && dataFormats[name] == nil {
+		errorMsg = append(errorMsg, fmt.Sprintf("format '%s' is not supported, use one of %s", name, strings.Join(formatNames(), ", ")))
+	}
+	switch layout := viper.GetString(section + ".layout"); layout {
+	case "", "file":
+	case "dir":
+		if name != "" && recordEncodings[name] == nil {
+			errorMsg = append(errorMsg, fmt.Sprintf("the dir layout doesn't support the %s format", name))
+		}
+	default:
+		errorMsg = append(errorMsg, fmt.Sprintf("layout '%s' is not supported, use file or dir", layout))
+	}
+	return errorMsg
+}
+
+// formatOf returns the name of the file format of a data file as told by its extension.
+// Files with an unknown extension are read and written as JSON.
+func formatOf(fileName string) string {
+	name := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
+	if alias, ok := formatExtensions[name]; ok {
+		name = alias
+	}
+	if dataFormats[name] == nil {
+		return "json"
+	}
+	return name
+}
+
+// readOutput reads a data file of any supported format and format version.
+// The file format is told by the extension of the file name.
+func readOutput(fileName string) (*Output, error) {
+	return readOutputAs(fileName, formatOf(fileName))
+}
+
+// readOutputAs reads a data file in the given file format. Directories are read in the dir layout.
+func readOutputAs(fileName string, format string) (*Output, error) {
+	if info, err := os.Stat(fileName); err == nil && info.IsDir() {
+		return readDirectory(fileName)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return dataFormats[format].read(f)
+}
+
+// writeOutput writes data to a file in the current format version. The file format
+// is told by the extension of the file name.
+func writeOutput(output *Output, fileName string) error {
+	return writeOutputAs(output, fileName, formatOf(fileName))
+}
+
+// writeOutputAs writes data to a file in the given file format
+func writeOutputAs(output *Output, fileName string, format string) error {
+	if output.Header == nil {
+		output.Header = &Header{}
+	}
+	output.Header.FormatVersion = FormatVersion
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := dataFormats[format].write(f, output); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeExport writes exported data to the output file in the format chosen with --format.
+// Unless an output file is given, its extension follows the format. With --layout dir,
+// the data is written to a directory named like the output file, without extension.
+func writeExport(output *Output) error {
+	fileName, format := exportFile()
+
+	var err error
+	if viper.GetString("export.layout") == "dir" {
+		if !RootCmd.PersistentFlags().Changed("output") {
+			fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		}
+		err = writeDirectory(output, fileName, format, viper.GetBool("export.split-html"))
+	} else {
+		err = writeOutputAs(output, fileName, format)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("== Exported to", fileName)
+	return nil
+}
+
+// exportFile returns the output file of an export and its format. Unless an output
+// file is given, its extension follows the format chosen with --format.
+func exportFile() (fileName string, format string) {
+	fileName, format = outputFile, viper.GetString("export.format")
+	if format == "" {
+		format = formatOf(fileName)
+	} else if !RootCmd.PersistentFlags().Changed("output") {
+		fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "." + format
+	}
+	return fileName, format
+}
+
+func readJSON(r io.Reader) (*Output, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := decodeOutput(b)
+	return data, err
+}
+
+func writeJSON(w io.Writer, output *Output) error {
+	b, err := JSONMarshalIndent(output, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// flatRecord is a single record of a data file, together with its kind and parent.
+// The tabular formats write records one after the other rather than nested.
+type flatRecord struct {
+	kind    string
+	parent  *content // the blog of an article or the product of a variant
+	content *content
+	sku     *string
+	options []string
+	fields  []*OutputField
+}
+
+// flattenOutput returns all records of a data file. Variants follow their product.
+func flattenOutput(output *Output) []*flatRecord {
+	var records []*flatRecord
+	for _, p := range output.Products {
+		records = append(records, &flatRecord{kind: kindProduct, content: p.content(), fields: p.Fields})
+		for _, v := range p.Variants {
+			records = append(records, &flatRecord{
+				kind:    kindVariant,
+				parent:  &content{Id: p.Id, Handle: p.Handle},
+				content: &content{Id: v.Id, Title: v.Title},
+				sku:     v.Sku,
+				options: v.Options,
+				fields:  v.Fields,
+			})
+		}
+	}
+	for _, p := range output.Pages {
+		records = append(records, &flatRecord{kind: kindPage, content: p.content(), fields: p.Fields})
+	}
+	for _, a := range output.Articles {
+		blog := &content{Id: a.BlogId, Handle: a.BlogHandle}
+		records = append(records, &flatRecord{kind: kindArticle, parent: blog, content: a.content(), fields: a.Fields})
+	}
+	for _, c := range output.Collections {
+		records = append(records, &flatRecord{kind: c.kind(), content: c.content(), fields: c.Fields})
+	}
+	if output.Shop != nil {
+		records = append(records, &flatRecord{kind: kindShop, content: &content{}, fields: output.Shop.Fields})
+	}
+	return records
+}
+
+// unflattenOutput puts records back into a data file with the given header. Variants are
+// added to the product that matches their parent by id or handle.
+func unflattenOutput(header *Header, records []*flatRecord) (*Output, error) {
+	if header == nil {
+		header = &Header{}
+	}
+	if header.FormatVersion == 0 {
+		header.FormatVersion = FormatVersion
+	}
+	output := &Output{Header: header}
+	for _, r := range records {
+		switch r.kind {
+		case kindProduct:
+			output.Products = append(output.Products, newProductOutput(r.content, r.fields))
+		case kindVariant:
+			product := findProductOutput(output.Products, r.parent)
+			if product == nil {
+				return nil, fmt.Errorf("variant %s: product not found", variantLabel(&VariantOutput{Id: r.content.Id, Sku: r.sku, Title: r.content.Title}))
+			}
+			product.Variants = append(product.Variants, &VariantOutput{
+				Id:      r.content.Id,
+				Sku:     r.sku,
+				Title:   r.content.Title,
+				Options: r.options,
+				Fields:  r.fields,
+			})
+		case kindPage:
+			output.Pages = append(output.Pages, newPageOutput(r.content, r.fields))
+		case kindArticle:
+			blog := r.parent
+			if blog == nil {
+				blog = &content{}
+			}
+			output.Articles = append(output.Articles, newArticleOutput(blog, r.content, r.fields))
+		case kindCustomCollection, kindSmartCollection:
+			output.Collections = append(output.Collections, newCollectionOutput(r.kind, r.content, r.fields))
+		case kindShop:
+			output.Shop = &ShopOutput{Fields: r.fields}
+		default:
+			return nil, fmt.Errorf("unknown kind '%s'", r.kind)
+		}
+	}
+	return output, nil
+}
+
+// findProductOutput returns the product identified by the id or handle of c
+func findProductOutput(products []*ProductOutput, c *content) *ProductOutput {
+	if c == nil {
+		return nil
+	}
+	for _, p := range products {
+		if c.Id != nil && p.Id != nil && *c.Id == *p.Id {
+			return p
+		}
+		if c.Handle != nil && p.Handle != nil && *c.Handle == *p.Handle {
+			return p
+		}
+	}
+	return nil
+}
+
+// headerNames are the names of the header values. Formats that can't hold the header as an
+// object write it as pairs of names and values.
+var headerNames = []string{"format_version", "store", "namespace", "command", "created_at"}
+
+// headerValue returns a value of a header by name, or "" if it isn't set
+func headerValue(h *Header, name string) string {
+	switch name {
+	case "format_version":
+		return strconv.Itoa(h.FormatVersion)
+	case "store":
+		return h.Store
+	case "namespace":
+		return h.Namespace
+	case "command":
+		return h.Command
+	case "created_at":
+		if h.CreatedAt != nil {
+			return h.CreatedAt.Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+// setHeaderValue sets a value of a header by name
+func setHeaderValue(h *Header, name string, value string) error {
+	switch name {
+	case "format_version":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid format_version '%s'", value)
+		}
+		h.FormatVersion = v
+	case "store":
+		h.Store = value
+	case "namespace":
+		h.Namespace = value
+	case "command":
+		h.Command = value
+	case "created_at":
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid created_at '%s'", value)
+		}
+		h.CreatedAt = &t
+	default:
+		return fmt.Errorf("unknown header value '%s'", name)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testOutput returns a data file with records of every kind
+func testOutput() *Output {
+	var accordion FieldData
+	for i := 0; i < 12; i++ {
+		accordion = append(accordion, []string{fmt.Sprintf("Row %d", i), "<ul>\n  <li>45 cm x 15 cm, 158 g&nbsp;</li>\n</ul>"})
+	}
+	smart := "smart"
+	created := time.Date(2019, 11, 5, 14, 30, 0, 0, time.UTC)
+	return &Output{
+		Header: &Header{
+			FormatVersion: FormatVersion,
+			Store:         "blackroll-ch",
+			Namespace:     "power-editor",
+			Command:       "export collection 1234 --include-variants",
+			CreatedAt:     &created,
+		},
+		Products: []*ProductOutput{{
+			Id:       intPtr(3894060097),
+			Handle:   strPtr("blackroll-med-45"),
+			Title:    strPtr("BLACKROLL® MED 45"),
+			BodyHtml: strPtr("<p>Die \"weiche\" Rolle, grün</p>"),
+			Fields: []*OutputField{
+				{Key: strPtr("accordion"), Data: accordion},
+				{Key: strPtr("tabs"), Data: FieldData{{"a", ""}, {"b", "c"}}},
+			},
+			Variants: []*VariantOutput{{
+				Id:      intPtr(12),
+				Sku:     strPtr("BR-45-GRN"),
+				Title:   strPtr("45 cm / green"),
+				Options: []string{"45 cm", "green"},
+				Fields:  []*OutputField{{Key: strPtr("size"), Data: FieldData{{"45 cm"}}}},
+			}},
+		}},
+		Pages: []*PageOutput{{Id: intPtr(1), Handle: strPtr("about-us"), Title: strPtr("About us")}},
+		Articles: []*ArticleOutput{{
+			Id: intPtr(2), BlogId: intPtr(3), BlogHandle: strPtr("news"), Handle: strPtr("hello"),
+			MetafieldsGlobalTitleTag: strPtr("Hello, world"),
+		}},
+		Collections: []*CollectionOutput{{
+			Id: intPtr(4), CollectionType: &smart, Handle: strPtr("rollen"),
+			Fields: []*OutputField{{Key: strPtr("video"), Data: FieldData{{"XGBQkxcM8DI"}}}},
+		}},
+		Shop: &ShopOutput{Fields: []*OutputField{{Key: strPtr("banner"), Data: FieldData{{"Sale", "50%"}}}}},
+	}
+}
+
+// roundTripLosses are the changes a round trip through a file format makes to testOutput
+var roundTripLosses = map[string]func(o *Output){
+	// Messages are keyed by handle (and SKU), as ids differ between stores
+	"po":  withoutIds,
+	"pot": withoutIds,
+}
+
+func withoutIds(o *Output) {
+	o.Products[0].Id = nil
+	v := o.Products[0].Variants[0]
+	v.Id, v.Title, v.Options = nil, nil, nil
+	o.Pages[0].Id = nil
+	o.Articles[0].Id, o.Articles[0].BlogId = nil, nil
+	o.Collections[0].Id = nil
+}
+
+func TestDataFormatsRoundTrip(t *testing.T) {
+	for _, name := range formatNames() {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := dataFormats[name].write(&buf, testOutput()); err != nil {
+				t.Fatal(err)
+			}
+			got, err := dataFormats[name].read(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := testOutput()
+			if lose := roundTripLosses[name]; lose != nil {
+				lose(want)
+			}
+			assertSameOutput(t, got, want)
+		})
+	}
+}
+
+// assertSameOutput fails a test if two data files differ
+func assertSameOutput(t *testing.T, got *Output, want *Output) {
+	t.Helper()
+	w, _ := JSONMarshalIndent(want, "", "  ")
+	g, _ := JSONMarshalIndent(got, "", "  ")
+	if !bytes.Equal(w, g) {
+		t.Errorf("round trip changed the data:\n got %s\nwant %s", g, w)
+	}
+}
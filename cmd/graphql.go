@@ -0,0 +1,724 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/spin"
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/viper"
+)
+
+// With --api graphql, products are exported with bulk operations of the GraphQL Admin API and
+// metafields are written in batches on import. All other requests use the REST API.
+
+// graphqlVersion is the version of the GraphQL Admin API the queries are written for
+const graphqlVersion = "2024-10"
+
+// metafieldsSetLimit is the number of metafields a single metafieldsSet mutation accepts
+const metafieldsSetLimit = 25
+
+// defaultMetafieldType is the type of metafields created by the GraphQL backend. Existing
+// metafields keep their type.
+const defaultMetafieldType = "multi_line_text_field"
+
+// bulkPollInterval is the time between two checks of a running bulk operation
+var bulkPollInterval = 2 * time.Second
+
+// useGraphQL tells if the GraphQL backend was chosen with --api graphql
+func useGraphQL() bool {
+	return viper.GetString("api") == "graphql"
+}
+
+// checkAPI returns an error message if the API chosen with --api is not supported
+func checkAPI() []string {
+	switch api := viper.GetString("api"); api {
+	case "", "rest", "graphql":
+		return nil
+	default:
+		return []string{fmt.Sprintf("api '%s' is not supported, use rest or graphql", api)}
+	}
+}
+
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type graphqlError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+type graphqlResponse struct {
+	Data       json.RawMessage `json:"data"`
+	Errors     []graphqlError  `json:"errors"`
+	Extensions struct {
+		Cost struct {
+			RequestedQueryCost float64 `json:"requestedQueryCost"`
+			ThrottleStatus     struct {
+				CurrentlyAvailable float64 `json:"currentlyAvailable"`
+				RestoreRate        float64 `json:"restoreRate"`
+			} `json:"throttleStatus"`
+		} `json:"cost"`
+	} `json:"extensions"`
+}
+
+// userError is an error in the input of a mutation
+type userError struct {
+	Field   []string `json:"field"`
+	Message string   `json:"message"`
+}
+
+// userErrorsError combines the user errors of a mutation into a single error
+func userErrorsError(userErrors []userError) error {
+	if len(userErrors) == 0 {
+		return nil
+	}
+	var messages []string
+	for _, e := range userErrors {
+		if len(e.Field) > 0 {
+			messages = append(messages, strings.Join(e.Field, ".")+": "+e.Message)
+		} else {
+			messages = append(messages, e.Message)
+		}
+	}
+	return errors.New(strings.Join(messages, ", "))
+}
+
+// graphqlQuery runs a query or mutation and decodes its data into v. Queries that exceed
+// the store's query cost limit are retried once enough of the cost has been restored.
+func graphqlQuery(query string, variables map[string]interface{}, v interface{}, client *shopify.Client) error {
+	for attempt := 0; ; attempt++ {
+		req, err := client.NewRequest("POST", "api/"+graphqlVersion+"/graphql.json", &graphqlRequest{Query: query, Variables: variables})
+		if err != nil {
+			return err
+		}
+		// Private apps authenticate with their password as access token
+		if password, ok := client.BaseURL.User.Password(); ok {
+			req.Header.Set("X-Shopify-Access-Token", password)
+		}
+		// Queries only read, so they can be retried like GET requests
+		if !strings.HasPrefix(strings.TrimSpace(query), "mutation") {
+			req.Header["Idempotency-Key"] = nil
+		}
+		var resp graphqlResponse
+		if _, err := client.Do(context.Background(), req, &resp); err != nil {
+			return err
+		}
+
+		if len(resp.Errors) > 0 {
+			if resp.Errors[0].Extensions.Code == "THROTTLED" && attempt < viper.GetInt("retries") {
+				delay := throttleDelay(&resp)
+				fmt.Fprintf(os.Stderr, "Retrying GraphQL query in %s, the query cost limit is used up (retry %d of %d)\n", delay, attempt+1, viper.GetInt("retries"))
+				time.Sleep(delay)
+				continue
+			}
+			var messages []string
+			for _, e := range resp.Errors {
+				messages = append(messages, e.Message)
+			}
+			return errors.New(strings.Join(messages, ", "))
+		}
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Data, v)
+	}
+}
+
+// throttleDelay returns how long it takes until the cost of a throttled query is restored
+func throttleDelay(resp *graphqlResponse) time.Duration {
+	cost := resp.Extensions.Cost
+	missing := cost.RequestedQueryCost - cost.ThrottleStatus.CurrentlyAvailable
+	if cost.ThrottleStatus.RestoreRate <= 0 || missing <= 0 {
+		return time.Second
+	}
+	return time.Duration(missing / cost.ThrottleStatus.RestoreRate * float64(time.Second))
+}
+
+// gid returns the global ID of a resource, e.g. "gid://shopify/Product/123"
+func gid(typeName string, id int) string {
+	return fmt.Sprintf("gid://shopify/%s/%d", typeName, id)
+}
+
+// parseGID returns the type name and numeric ID of a global ID
+func parseGID(globalId string) (typeName string, id int, err error) {
+	parts := strings.Split(strings.TrimPrefix(globalId, "gid://shopify/"), "/")
+	if len(parts) != 2 {
+		return "", 0, fmt.Errorf("'%s' is not a global ID", globalId)
+	}
+	// Some IDs carry parameters, like "gid://shopify/Product/123?v=1"
+	number := strings.SplitN(parts[1], "?", 2)[0]
+	id, err = strconv.Atoi(number)
+	if err != nil {
+		return "", 0, fmt.Errorf("'%s' is not a global ID", globalId)
+	}
+	return parts[0], id, nil
+}
+
+// ownerTypes are the GraphQL types of the resources in metafield owner paths
+var ownerTypes = map[string]string{
+	"products":    "Product",
+	"variants":    "ProductVariant",
+	"pages":       "Page",
+	"articles":    "Article",
+	"collections": "Collection",
+}
+
+// ownerGID returns the global ID of the resource at a metafield owner path
+func ownerGID(owner string, client *shopify.Client) (string, error) {
+	if owner == "" {
+		var data struct {
+			Shop struct {
+				Id string `json:"id"`
+			} `json:"shop"`
+		}
+		if err := graphqlQuery(`{ shop { id } }`, nil, &data, client); err != nil {
+			return "", err
+		}
+		return data.Shop.Id, nil
+	}
+	parts := strings.Split(owner, "/")
+	if len(parts) < 2 || ownerTypes[parts[len(parts)-2]] == "" {
+		return "", fmt.Errorf("metafields of %s can't be written with GraphQL", owner)
+	}
+	id, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return "", err
+	}
+	return gid(ownerTypes[parts[len(parts)-2]], id), nil
+}
+
+// graphqlMetafield is a metafield as returned by the GraphQL API
+type graphqlMetafield struct {
+	Id    string `json:"id"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+// metafield converts the metafield to the type of the REST client. Its type is kept as value type.
+func (m *graphqlMetafield) metafield() (*shopify.Metafield, error) {
+	_, id, err := parseGID(m.Id)
+	if err != nil {
+		return nil, err
+	}
+	key, value := m.Key, m.Value
+	out := &shopify.Metafield{Id: &id, Key: &key, Value: &value}
+	if m.Type != "" {
+		typeName := m.Type
+		out.ValueType = &typeName
+	}
+	return out, nil
+}
+
+const listMetafieldsQuery = `query($owner: ID!, $namespace: String!, $after: String) {
+  node(id: $owner) {
+    ... on HasMetafields {
+      metafields(namespace: $namespace, first: 250, after: $after) {
+        edges { node { id key value type } }
+        pageInfo { hasNextPage endCursor }
+      }
+    }
+  }
+}`
+
+// graphqlListMetafields returns the metafields of a namespace attached to a resource,
+// a page of 250 at a time
+func graphqlListMetafields(ownerId string, namespace string, client *shopify.Client) ([]*shopify.Metafield, error) {
+	var metafields []*shopify.Metafield
+	vars := map[string]interface{}{"owner": ownerId, "namespace": namespace}
+	for {
+		var data struct {
+			Node *struct {
+				Metafields struct {
+					Edges []struct {
+						Node graphqlMetafield `json:"node"`
+					} `json:"edges"`
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+				} `json:"metafields"`
+			} `json:"node"`
+		}
+		if err := graphqlQuery(listMetafieldsQuery, vars, &data, client); err != nil {
+			return nil, err
+		}
+		if data.Node == nil {
+			return nil, fmt.Errorf("found no %s", ownerId)
+		}
+		for _, edge := range data.Node.Metafields.Edges {
+			m, err := edge.Node.metafield()
+			if err != nil {
+				return nil, err
+			}
+			metafields = append(metafields, m)
+		}
+		page := data.Node.Metafields.PageInfo
+		if !page.HasNextPage {
+			return metafields, nil
+		}
+		vars["after"] = page.EndCursor
+	}
+}
+
+const metafieldsSetMutation = `mutation($metafields: [MetafieldsSetInput!]!) {
+  metafieldsSet(metafields: $metafields) { userErrors { field message } }
+}`
+
+const metafieldsDeleteMutation = `mutation($metafields: [MetafieldIdentifierInput!]!) {
+  metafieldsDelete(metafields: $metafields) { userErrors { field message } }
+}`
+
+// graphqlStore is a store that reads metafields and exports products with the GraphQL API.
+// Metafields are written in batches rather than one by one. Everything else, including the
+// global SEO metafields, is left to the REST API.
+type graphqlStore struct {
+	*restStore
+}
+
+func (s *graphqlStore) ListMetafields(ref contentRef, namespace string) ([]*shopify.Metafield, error) {
+	if namespace == "global" {
+		return s.restStore.ListMetafields(ref, namespace)
+	}
+	ownerId, err := ownerGID(ref.owner(), s.client)
+	if err != nil {
+		return nil, err
+	}
+	metafields, err := graphqlListMetafields(ownerId, namespace, s.client)
+	for _, m := range metafields {
+		m.Namespace = &namespace
+	}
+	return metafields, err
+}
+
+// SetMetafields writes the metafields with as few metafieldsSet mutations as possible.
+// Metafields without a type get the default type.
+func (s *graphqlStore) SetMetafields(ref contentRef, namespace string, metafields []*shopify.Metafield) error {
+	ownerId, err := ownerGID(ref.owner(), s.client)
+	if err != nil {
+		return err
+	}
+	var set []map[string]interface{}
+	for _, m := range metafields {
+		typeName := defaultMetafieldType
+		if m.ValueType != nil {
+			typeName = *m.ValueType
+		}
+		set = append(set, map[string]interface{}{
+			"ownerId": ownerId, "namespace": namespace, "key": *m.Key, "value": *m.Value, "type": typeName,
+		})
+	}
+
+	for start := 0; start < len(set); start += metafieldsSetLimit {
+		end := start + metafieldsSetLimit
+		if end > len(set) {
+			end = len(set)
+		}
+		var data struct {
+			MetafieldsSet struct {
+				UserErrors []userError `json:"userErrors"`
+			} `json:"metafieldsSet"`
+		}
+		if err := graphqlQuery(metafieldsSetMutation, map[string]interface{}{"metafields": set[start:end]}, &data, s.client); err != nil {
+			return err
+		}
+		if err := userErrorsError(data.MetafieldsSet.UserErrors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMetafields deletes the metafields by namespace and key with a single mutation
+func (s *graphqlStore) DeleteMetafields(ref contentRef, metafields []*shopify.Metafield) error {
+	ownerId, err := ownerGID(ref.owner(), s.client)
+	if err != nil {
+		return err
+	}
+	var remove []map[string]interface{}
+	for _, m := range metafields {
+		if m.Namespace == nil {
+			return fmt.Errorf("metafield %s has no namespace", *m.Key)
+		}
+		remove = append(remove, map[string]interface{}{"ownerId": ownerId, "namespace": *m.Namespace, "key": *m.Key})
+	}
+	var data struct {
+		MetafieldsDelete struct {
+			UserErrors []userError `json:"userErrors"`
+		} `json:"metafieldsDelete"`
+	}
+	if err := graphqlQuery(metafieldsDeleteMutation, map[string]interface{}{"metafields": remove}, &data, s.client); err != nil {
+		return err
+	}
+	return userErrorsError(data.MetafieldsDelete.UserErrors)
+}
+
+// ExportProducts exports the products of a collection or those matching the filter
+// with a bulk operation
+func (s *graphqlStore) ExportProducts(filter *ProductFilter, collectionId int, resumeAt func(ids []*int) (int, error), emit func(*ProductOutput) error) error {
+	namespace := viper.GetString("export.namespace")
+	query := bulkProductsQuery(filter, namespace)
+	if collectionId != 0 {
+		query = bulkCollectionQuery(collectionId, namespace)
+	}
+	return graphqlExportProducts(query, s.client, resumeAt, emit)
+}
+
+const bulkRunMutation = `mutation($query: String!) {
+  bulkOperationRunQuery(query: $query) { bulkOperation { id } userErrors { field message } }
+}`
+
+const bulkStatusQuery = `query($id: ID!) {
+  node(id: $id) { ... on BulkOperation { status errorCode objectCount url } }
+}`
+
+// runBulkQuery starts a bulk operation for the query, waits until it is done and returns
+// the URL of its JSONL result. The URL is empty if the query found nothing.
+func runBulkQuery(query string, client *shopify.Client) (string, error) {
+	var started struct {
+		BulkOperationRunQuery struct {
+			BulkOperation *struct {
+				Id string `json:"id"`
+			} `json:"bulkOperation"`
+			UserErrors []userError `json:"userErrors"`
+		} `json:"bulkOperationRunQuery"`
+	}
+	if err := graphqlQuery(bulkRunMutation, map[string]interface{}{"query": query}, &started, client); err != nil {
+		return "", err
+	}
+	if err := userErrorsError(started.BulkOperationRunQuery.UserErrors); err != nil {
+		return "", err
+	}
+	if started.BulkOperationRunQuery.BulkOperation == nil {
+		return "", errors.New("the bulk operation wasn't started")
+	}
+
+	s := spin.New("  \033[36m Running bulk operation \033[m %s")
+	s.Set(spin.Spin1)
+	s.Start()
+	defer s.Stop()
+	id := started.BulkOperationRunQuery.BulkOperation.Id
+	for {
+		var status struct {
+			Node struct {
+				Status    string  `json:"status"`
+				ErrorCode *string `json:"errorCode"`
+				URL       *string `json:"url"`
+			} `json:"node"`
+		}
+		if err := graphqlQuery(bulkStatusQuery, map[string]interface{}{"id": id}, &status, client); err != nil {
+			return "", err
+		}
+		switch status.Node.Status {
+		case "COMPLETED":
+			if status.Node.URL == nil {
+				return "", nil
+			}
+			return *status.Node.URL, nil
+		case "FAILED", "CANCELED", "EXPIRED":
+			reason := strings.ToLower(status.Node.Status)
+			if status.Node.ErrorCode != nil {
+				reason += ": " + *status.Node.ErrorCode
+			}
+			return "", fmt.Errorf("bulk operation %s", reason)
+		}
+		time.Sleep(bulkPollInterval)
+	}
+}
+
+// readBulkResult downloads the JSONL result of a bulk operation and hands each line to fn
+func readBulkResult(url string, fn func(line []byte) error) error {
+	if url == "" {
+		return nil
+	}
+	// The URL is signed, so it must be fetched without the store's credentials
+	client := &http.Client{Transport: newRetryTransport(newHTTPTransport(), viper.GetInt("retries"), os.Stderr)}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("can't download the bulk operation result: %s", resp.Status)
+	}
+	return eachJSONLine(resp.Body, fn)
+}
+
+// eachJSONLine hands each non-empty line of r to fn
+func eachJSONLine(r io.Reader, fn func(line []byte) error) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			if err := fn(b); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// bulkLine is a line of a bulk operation result. Records of nested connections, like
+// metafields, are lines of their own that refer to their parent.
+type bulkLine struct {
+	Id              string          `json:"id"`
+	ParentId        string          `json:"__parentId"`
+	Handle          *string         `json:"handle"`
+	Title           *string         `json:"title"`
+	DescriptionHtml *string         `json:"descriptionHtml"`
+	Seo             *bulkSeo        `json:"seo"`
+	Sku             *string         `json:"sku"`
+	SelectedOptions []*bulkOption   `json:"selectedOptions"`
+	Product         *bulkProductRef `json:"product"`
+	Key             string          `json:"key"`
+	Value           string          `json:"value"`
+}
+
+type bulkSeo struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+}
+
+type bulkOption struct {
+	Value string `json:"value"`
+}
+
+type bulkProductRef struct {
+	Id string `json:"id"`
+}
+
+// bulkProductFields are the fields queried for each product
+const bulkProductFields = `id handle title descriptionHtml seo { title description }
+metafields(namespace: %s) { edges { node { id key value } } }`
+
+// bulkProductsQuery returns the bulk query for the products matching a filter
+func bulkProductsQuery(filter *ProductFilter, namespace string) string {
+	var terms []string
+	add := func(field string, value string) {
+		if value != "" {
+			terms = append(terms, field+":"+strconv.Quote(value))
+		}
+	}
+	add("vendor", filter.Vendor)
+	add("product_type", filter.ProductType)
+	add("tag", filter.Tag)
+	if filter.PublishedStatus != "" && filter.PublishedStatus != "any" {
+		add("published_status", filter.PublishedStatus)
+	}
+	if !filter.UpdatedAtMin.IsZero() {
+		terms = append(terms, "updated_at:>"+strconv.Quote(filter.UpdatedAtMin.Format(time.RFC3339)))
+	}
+	if len(filter.Handles) > 0 {
+		var handles []string
+		for _, handle := range filter.Handles {
+			handles = append(handles, "handle:"+strconv.Quote(handle))
+		}
+		terms = append(terms, "("+strings.Join(handles, " OR ")+")")
+	}
+	if len(filter.Ids) > 0 {
+		var ids []string
+		for _, id := range filter.Ids {
+			ids = append(ids, fmt.Sprintf("id:%d", id))
+		}
+		terms = append(terms, "("+strings.Join(ids, " OR ")+")")
+	}
+
+	args := ""
+	if len(terms) > 0 {
+		args = "(query: " + strconv.Quote(strings.Join(terms, " AND ")) + ")"
+	}
+	fields := fmt.Sprintf(bulkProductFields, strconv.Quote(namespace))
+	return "{ products" + args + " { edges { node { " + fields + " } } } }"
+}
+
+// bulkCollectionQuery returns the bulk query for the products of a collection
+func bulkCollectionQuery(collectionId int, namespace string) string {
+	fields := fmt.Sprintf(bulkProductFields, strconv.Quote(namespace))
+	return fmt.Sprintf("{ collection(id: %s) { products { edges { node { %s } } } } }", strconv.Quote(gid("Collection", collectionId)), fields)
+}
+
+// bulkVariantsProductLimit is the number of products whose variants are fetched by one bulk query
+const bulkVariantsProductLimit = 250
+
+// bulkVariantsQuery returns the bulk query for the metafields of the variants of the given
+// products. Bulk queries can only nest connections two levels deep, so variants need a query
+// of their own.
+func bulkVariantsQuery(productIds []*int, namespace string) string {
+	var ids []string
+	for _, id := range productIds {
+		ids = append(ids, fmt.Sprintf("product_id:%d", *id))
+	}
+	return fmt.Sprintf(`{ productVariants(query: %s) { edges { node { id sku title selectedOptions { value } product { id }
+metafields(namespace: %s) { edges { node { id key value } } } } } } }`, strconv.Quote(strings.Join(ids, " OR ")), strconv.Quote(namespace))
+}
+
+// bulkRecord is a product or variant of a bulk operation result, together with its metafields
+type bulkRecord struct {
+	line       *bulkLine
+	metafields []*shopify.Metafield
+}
+
+// readBulkRecords runs a bulk query and returns the records it found in order. The
+// metafields of a record are added to it.
+func readBulkRecords(query string, client *shopify.Client) ([]*bulkRecord, error) {
+	url, err := runBulkQuery(query, client)
+	if err != nil {
+		return nil, err
+	}
+	var records []*bulkRecord
+	byId := map[string]*bulkRecord{}
+	err = readBulkResult(url, func(b []byte) error {
+		var line bulkLine
+		if err := json.Unmarshal(b, &line); err != nil {
+			return err
+		}
+		if line.ParentId == "" {
+			r := &bulkRecord{line: &line}
+			records = append(records, r)
+			byId[line.Id] = r
+			return nil
+		}
+		parent := byId[line.ParentId]
+		if parent == nil {
+			return fmt.Errorf("the bulk operation result has a metafield of the unknown record %s", line.ParentId)
+		}
+		m, err := (&graphqlMetafield{Id: line.Id, Key: line.Key, Value: line.Value}).metafield()
+		if err != nil {
+			return err
+		}
+		parent.metafields = append(parent.metafields, m)
+		return nil
+	})
+	return records, err
+}
+
+// graphqlExportProducts runs the bulk query and hands the export data of each product to emit,
+// in the order of the result. resumeAt returns the index of the first product to export.
+// Products without content are skipped like in a REST export.
+func graphqlExportProducts(query string, client *shopify.Client, resumeAt func(ids []*int) (int, error), emit func(*ProductOutput) error) error {
+	products, err := readBulkRecords(query, client)
+	if err != nil {
+		return err
+	}
+	ids := make([]*int, len(products))
+	for i, p := range products {
+		_, id, err := parseGID(p.line.Id)
+		if err != nil {
+			return err
+		}
+		ids[i] = &id
+	}
+	start, err := resumeAt(ids)
+	if err != nil {
+		return err
+	}
+	var variants map[int][]*VariantOutput
+	if viper.GetBool("export.include-variants") {
+		if variants, err = graphqlExportVariants(ids[start:], viper.GetString("export.namespace"), client); err != nil {
+			return err
+		}
+	}
+	for i := start; i < len(products); i++ {
+		p := products[i]
+		product := &shopify.Product{Id: ids[i], Handle: p.line.Handle}
+		if viper.GetBool("export.include-product-info") {
+			product.Title, product.BodyHtml = p.line.Title, p.line.DescriptionHtml
+		}
+		pvariants := variants[*ids[i]]
+		if !hasExportContent(p.metafields, pvariants) {
+			continue
+		}
+		var titleTag, descriptionTag *string
+		if p.line.Seo != nil {
+			titleTag, descriptionTag = p.line.Seo.Title, p.line.Seo.Description
+		}
+		if err := emit(productOutput(product, titleTag, descriptionTag, p.metafields, pvariants)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// graphqlExportVariants returns the variants of the given products that have power-editor
+// metafields, by product ID
+func graphqlExportVariants(productIds []*int, namespace string, client *shopify.Client) (map[int][]*VariantOutput, error) {
+	var variants []*bulkRecord
+	for start := 0; start < len(productIds); start += bulkVariantsProductLimit {
+		end := start + bulkVariantsProductLimit
+		if end > len(productIds) {
+			end = len(productIds)
+		}
+		records, err := readBulkRecords(bulkVariantsQuery(productIds[start:end], namespace), client)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, records...)
+	}
+
+	byProduct := map[int][]*VariantOutput{}
+	for _, v := range variants {
+		if len(v.metafields) == 0 || v.line.Product == nil {
+			continue
+		}
+		_, productId, err := parseGID(v.line.Product.Id)
+		if err != nil {
+			return nil, err
+		}
+		_, id, err := parseGID(v.line.Id)
+		if err != nil {
+			return nil, err
+		}
+		var options []string
+		for _, option := range v.line.SelectedOptions {
+			options = append(options, option.Value)
+		}
+		byProduct[productId] = append(byProduct[productId], &VariantOutput{
+			Id:      &id,
+			Sku:     v.line.Sku,
+			Title:   v.line.Title,
+			Options: options,
+			Fields:  GenerateProductDataOutput(v.metafields),
+		})
+	}
+	return byProduct, nil
+}
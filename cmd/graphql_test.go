@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/viper"
+)
+
+// graphqlTestServer answers GraphQL requests with handle and serves bulk operation results
+func graphqlTestServer(t *testing.T, handle func(req *graphqlRequest) interface{}) (*httptest.Server, *shopify.Client) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/result.jsonl" {
+			fmt.Fprint(w, bulkResult)
+			return
+		}
+		if r.URL.Path != "/admin/api/"+graphqlVersion+"/graphql.json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("X-Shopify-Access-Token") != "password" {
+			t.Error("the access token wasn't sent")
+		}
+		var req graphqlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": handle(&req)})
+	}))
+	client := NewClient(StoreCredentials{Key: "key", Password: "password", Store: "example"})
+	client.BaseURL, _ = url.Parse(server.URL + "/admin/")
+	client.BaseURL.User = url.UserPassword("key", "password")
+	return server, client
+}
+
+const bulkResult = `{"id":"gid://shopify/Product/1","handle":"roll","title":"Roll","descriptionHtml":"<p>Roll</p>","seo":{"title":"Roll SEO","description":null}}
+{"id":"gid://shopify/Metafield/11","key":"accordion","value":"a<!--|col|-->b","__parentId":"gid://shopify/Product/1"}
+{"id":"gid://shopify/Product/2","handle":"empty","title":"Empty","descriptionHtml":"","seo":{"title":null,"description":null}}
+{"id":"gid://shopify/Product/3","handle":"ball","title":"Ball","descriptionHtml":"","seo":{"title":null,"description":null}}
+{"id":"gid://shopify/Metafield/31","key":"teaser","value":"Ball","__parentId":"gid://shopify/Product/3"}
+`
+
+func TestGraphQLExportProducts(t *testing.T) {
+	defer func(d time.Duration) { bulkPollInterval = d }(bulkPollInterval)
+	bulkPollInterval = time.Millisecond
+	viper.Set("export.namespace", "power-editor")
+	defer viper.Set("export.namespace", nil)
+	viper.Set("export.include-variants", true)
+	defer viper.Set("export.include-variants", nil)
+
+	var server *httptest.Server
+	var variantQueries []string
+	polls := 0
+	server, client := graphqlTestServer(t, func(req *graphqlRequest) interface{} {
+		switch {
+		case strings.Contains(req.Query, "bulkOperationRunQuery") && strings.Contains(req.Variables["query"].(string), "productVariants"):
+			variantQueries = append(variantQueries, req.Variables["query"].(string))
+			return map[string]interface{}{"bulkOperationRunQuery": map[string]interface{}{"bulkOperation": map[string]string{"id": "gid://shopify/BulkOperation/2"}}}
+		case strings.Contains(req.Query, "bulkOperationRunQuery"):
+			if q := req.Variables["query"].(string); !strings.Contains(q, `collection(id: "gid://shopify/Collection/7")`) {
+				t.Errorf("unexpected bulk query %s", q)
+			}
+			return map[string]interface{}{"bulkOperationRunQuery": map[string]interface{}{"bulkOperation": map[string]string{"id": "gid://shopify/BulkOperation/1"}}}
+		case strings.Contains(req.Query, "BulkOperation"):
+			polls++
+			if polls < 2 {
+				return map[string]interface{}{"node": map[string]interface{}{"status": "RUNNING"}}
+			}
+			return map[string]interface{}{"node": map[string]interface{}{"status": "COMPLETED", "url": server.URL + "/result.jsonl"}}
+		}
+		t.Errorf("unexpected query %s", req.Query)
+		return nil
+	})
+	defer server.Close()
+
+	var got []*ProductOutput
+	resumeAt := func(ids []*int) (int, error) { return 0, nil }
+	err := graphqlExportProducts(bulkCollectionQuery(7, "power-editor"), client, resumeAt, func(p *ProductOutput) error {
+		got = append(got, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The product without metafields is skipped
+	if len(got) != 2 || *got[0].Handle != "roll" || *got[1].Handle != "ball" {
+		t.Fatalf("got %d products", len(got))
+	}
+	if *got[0].Id != 1 || *got[0].MetafieldsGlobalTitleTag != "Roll SEO" || got[0].Title != nil {
+		t.Errorf("got product %+v", got[0])
+	}
+	if f := got[0].Fields[0]; *f.Key != "accordion" || *f.Id != 11 || f.Data[0][1] != "b" {
+		t.Errorf("got field %+v", f)
+	}
+	// Only the variants of the exported products are fetched
+	want := `productVariants(query: "product_id:1 OR product_id:2 OR product_id:3")`
+	if len(variantQueries) != 1 || !strings.Contains(variantQueries[0], want) {
+		t.Errorf("got variant queries %v, want one containing %s", variantQueries, want)
+	}
+}
+
+func TestGraphQLReconcileMetafields(t *testing.T) {
+	viper.Set("import.namespace", "power-editor")
+	defer viper.Set("import.namespace", nil)
+
+	var batches [][]interface{}
+	server, client := graphqlTestServer(t, func(req *graphqlRequest) interface{} {
+		switch {
+		case strings.Contains(req.Query, "HasMetafields"):
+			if req.Variables["owner"] != "gid://shopify/Product/5" {
+				t.Errorf("got owner %v", req.Variables["owner"])
+			}
+			edges := []interface{}{
+				map[string]interface{}{"node": map[string]string{"id": "gid://shopify/Metafield/1", "key": "key0", "value": "old", "type": "string"}},
+			}
+			return map[string]interface{}{"node": map[string]interface{}{"metafields": map[string]interface{}{"edges": edges}}}
+		case strings.Contains(req.Query, "metafieldsSet"):
+			batches = append(batches, req.Variables["metafields"].([]interface{}))
+			return map[string]interface{}{"metafieldsSet": map[string]interface{}{"userErrors": []interface{}{}}}
+		}
+		t.Errorf("unexpected query %s", req.Query)
+		return nil
+	})
+	defer server.Close()
+
+	var metafields []*shopify.Metafield
+	for i := 0; i < 30; i++ {
+		key, value := fmt.Sprintf("key%d", i), "new"
+		metafields = append(metafields, &shopify.Metafield{Key: &key, Value: &value})
+	}
+	store := &graphqlStore{&restStore{client: client}}
+	if err := ReconcileMetafields(contentRef{kind: kindProduct, id: 5}, metafields, false, store); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batches) != 2 || len(batches[0]) != metafieldsSetLimit || len(batches[1]) != 5 {
+		t.Fatalf("got %d batches", len(batches))
+	}
+	first := batches[0][0].(map[string]interface{})
+	second := batches[0][1].(map[string]interface{})
+	if first["key"] != "key0" || first["type"] != "string" || second["type"] != defaultMetafieldType {
+		t.Errorf("existing metafields should keep their type, got %v and %v", first, second)
+	}
+}
+
+func TestGraphQLListMetafieldsPages(t *testing.T) {
+	server, client := graphqlTestServer(t, func(req *graphqlRequest) interface{} {
+		after, _ := req.Variables["after"].(string)
+		id, next := "gid://shopify/Metafield/1", true
+		if after == "cursor1" {
+			id, next = "gid://shopify/Metafield/2", false
+		}
+		edges := []interface{}{map[string]interface{}{"node": map[string]string{"id": id, "key": "key", "value": "value"}}}
+		pageInfo := map[string]interface{}{"hasNextPage": next, "endCursor": "cursor1"}
+		return map[string]interface{}{"node": map[string]interface{}{"metafields": map[string]interface{}{"edges": edges, "pageInfo": pageInfo}}}
+	})
+	defer server.Close()
+
+	metafields, err := graphqlListMetafields("gid://shopify/Product/5", "power-editor", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metafields) != 2 || *metafields[1].Id != 2 {
+		t.Errorf("got %d metafields, want both pages", len(metafields))
+	}
+}
+
+func TestBulkProductsQuery(t *testing.T) {
+	filter := &ProductFilter{Vendor: "Blackroll", PublishedStatus: "published", Ids: []int{1, 2}}
+	got := bulkProductsQuery(filter, "power-editor")
+	want := `products(query: "vendor:\"Blackroll\" AND published_status:\"published\" AND (id:1 OR id:2)")`
+	if !strings.Contains(got, want) {
+		t.Errorf("got %s, want it to contain %s", got, want)
+	}
+	if got := bulkProductsQuery(&ProductFilter{PublishedStatus: "any"}, "power-editor"); !strings.HasPrefix(got, "{ products { edges") {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestOwnerGID(t *testing.T) {
+	tests := map[string]string{
+		"products/1":            "gid://shopify/Product/1",
+		"products/1/variants/2": "gid://shopify/ProductVariant/2",
+		"blogs/3/articles/4":    "gid://shopify/Article/4",
+		"collections/5":         "gid://shopify/Collection/5",
+		"pages/6":               "gid://shopify/Page/6",
+	}
+	for owner, want := range tests {
+		if got, err := ownerGID(owner, nil); err != nil || got != want {
+			t.Errorf("ownerGID(%q) = %q, %v, want %q", owner, got, err, want)
+		}
+	}
+	if typeName, id, err := parseGID("gid://shopify/Product/123?v=1"); err != nil || typeName != "Product" || id != 123 {
+		t.Errorf("got %s %d %v", typeName, id, err)
+	}
+}
@@ -21,13 +21,11 @@
 package cmd
 
 import (
-	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/caarlos0/spin"
@@ -46,9 +44,10 @@ var importCmd = &cobra.Command{
 
 	// Do all the error handling pre run
 	PreRunE: func(cmd *cobra.Command, args []string) error {
+		bindFlags(cmd, "import", "primary-key", "format", "metafields-only", "dry-run", "prune", "backup-dir", "no-backup", "resume")
 
 		// Check for required API credentials
-		errorMsg := checkGlobalRequiredFlags()
+		errorMsg := checkGlobalRequiredFlags("import")
 
 		key := viper.GetString("import.primary-key")
 		if !allowedPrimaryKeys[key] && key != "id" {
@@ -64,105 +63,400 @@ var importCmd = &cobra.Command{
 			}
 		}
 
-		if len(errorMsg) == 1 {
-			return errors.New(errorMsg[0])
-		} else if len(errorMsg) > 0 {
-			return errors.New("\n - " + strings.Join(errorMsg, "\n - "))
+		return requiredFlagsError(errorMsg)
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := GetStore("import")
+		format := viper.GetString("import.format")
+		if format == "" {
+			format = formatOf(fileName)
 		}
-		return nil
+		im := newImporter(store)
+		if err := im.keepCheckpoint(fileName, format); err != nil {
+			return err
+		}
+		err := eachRecord(fileName, format, func(record *ndjsonRecord, i int, total int) error {
+			if h := record.Header; h != nil {
+				if h.Store != "" && h.CreatedAt != nil {
+					fmt.Printf("== Importing data exported from %s (namespace %s) on %s\n", h.Store, h.Namespace, h.CreatedAt.Format("2006-01-02 15:04"))
+				}
+				return nil
+			}
+			return im.importLine(record, i, total)
+		})
+		if err != nil {
+			if im.backup != nil && im.backup.journal != nil {
+				fmt.Println("   The backup so far is in", im.backup.journalName())
+			}
+			if im.checkpoint != nil {
+				fmt.Println("   Run the same command with --resume to continue")
+			}
+			return fmt.Errorf("can't import %s: %v", fileName, err)
+		}
+		return im.finish()
 	},
+}
 
-	Run: func(cmd *cobra.Command, args []string) {
-		client := GetClient()
-		file, _ := ioutil.ReadFile(fileName)
-		var data Output
-		json.Unmarshal(file, &data)
-		// Loop over all products
-		for i, p := range data.Products {
-			progress := fmt.Sprintf("%d of %d", i, len(data.Products))
-			s := spin.New("  \033[36m Importing product " + progress + "\033[m %s")
-			s.Set(spin.Spin1)
-			s.Start()
+// importer writes exported records into a store. It takes a backup of each resource
+// before changing it, or only plans the changes in a dry run.
+// The import of a data file keeps its progress in a checkpoint.
+type importer struct {
+	store      Store
+	dryRun     bool
+	backup     *backup
+	plans      []*ImportPlan
+	checkpoint *checkpoint
+	// resumed is the number of products already imported by an interrupted run
+	resumed int
+	// retry are the indexes of the products the interrupted run failed to import
+	retry map[int]bool
+	// failed is the number of records that couldn't be imported
+	failed int
+}
 
-			var productId *int
+func newImporter(store Store) *importer {
+	im := &importer{store: store, dryRun: viper.GetBool("import.dry-run")}
+	if !im.dryRun && !viper.GetBool("import.no-backup") {
+		im.backup = newBackup(viper.GetString("import.backup-dir"), store)
+		fmt.Println("== Writing backup to", im.backup.fileName)
+	}
+	return im
+}
 
+// keepCheckpoint records the progress of importing the data file in a checkpoint next to it.
+// With --resume, the products imported by an interrupted run are skipped.
+func (im *importer) keepCheckpoint(dataFile string, format string) error {
+	if im.dryRun {
+		return nil
+	}
+	params := map[string]string{
+		"store":           im.store.Name(),
+		"namespace":       viper.GetString("import.namespace"),
+		"format":          format,
+		"primary-key":     viper.GetString("import.primary-key"),
+		"metafields-only": strconv.FormatBool(viper.GetBool("import.metafields-only")),
+		"prune":           strconv.FormatBool(viper.GetBool("import.prune")),
+	}
+	if !viper.GetBool("import.resume") {
+		im.checkpoint = newCheckpoint(checkpointFile(dataFile), "import", params)
+		return im.checkpoint.save()
+	}
+	c, err := loadCheckpoint(checkpointFile(dataFile), "import", params)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("== Resuming the import of %s (%s)\n", dataFile, c.describe())
+	im.checkpoint, im.resumed, im.retry = c, c.Done, make(map[int]bool)
+	for _, i := range c.Failed[kindProduct] {
+		im.retry[i] = true
+	}
+	// Products that fail again are added back
+	c.Failed = nil
+	return nil
+}
+
+// importLine imports a record of a data file, looking up the resource it belongs to
+// by the configured primary key. Products imported by an interrupted run are skipped,
+// those it failed to import are retried. Other records are always imported again.
+func (im *importer) importLine(r *ndjsonRecord, i int, total int) error {
+	store := im.store
+	switch {
+	case r.Product != nil:
+		p := r.Product
+		if i < im.resumed {
+			// The last skipped product must be the last one of the checkpoint
+			if last := im.checkpoint.LastProductId; i == im.resumed-1 && last != nil && (p.Id == nil || *p.Id != *last) {
+				return fmt.Errorf("product %d of the data file isn't the last one of the checkpoint, start over without --resume", i)
+			}
+			if !im.retry[i] {
+				return nil
+			}
+		}
+		err := im.importRecord(kindProduct, i, total, p, func() (contentRef, error) {
 			// Get ID of the product whose metafields will be updated
-			if key := viper.GetString("import.primary-key"); allowedPrimaryKeys[key] {
+			productId, err := resolveProductId(p, store)
+			if err != nil {
+				return contentRef{}, err
+			}
+			return contentRef{kind: kindProduct, id: *productId}, nil
+		})
+		return im.imported(kindProduct, i, p.Id, err)
+	case r.Page != nil:
+		err := im.importRecord(kindPage, i, total, r.Page, func() (contentRef, error) {
+			return resolvePageRef(r.Page, store)
+		})
+		return im.imported(kindPage, i, nil, err)
+	case r.Article != nil:
+		err := im.importRecord(kindArticle, i, total, r.Article, func() (contentRef, error) {
+			return resolveArticleRef(r.Article, store)
+		})
+		return im.imported(kindArticle, i, nil, err)
+	case r.Collection != nil:
+		err := im.importRecord("collection", i, total, r.Collection, func() (contentRef, error) {
+			return resolveCollectionRef(r.Collection, store)
+		})
+		return im.imported("collection", i, nil, err)
+	case r.Shop != nil:
+		err := im.importRecord(kindShop, i, total, r.Shop, func() (contentRef, error) {
+			return contentRef{kind: kindShop}, nil
+		})
+		return im.imported(kindShop, i, nil, err)
+	}
+	return nil
+}
 
-				// Get the key (handle or title) as string
-				f := reflect.ValueOf(p).Elem().FieldByName(strings.Title(key))
-				keyValue := reflect.Indirect(f).String()
+// imported records in the checkpoint that record i of a kind has been imported, or that
+// it failed and is to be retried by a resumed run. Only products move the checkpoint on.
+func (im *importer) imported(kind string, i int, id *int, err error) error {
+	if err != nil {
+		im.failed++
+	}
+	c := im.checkpoint
+	if c == nil {
+		return nil
+	}
+	if err != nil {
+		if c.Failed == nil {
+			c.Failed = make(map[string][]int)
+		}
+		c.Failed[kind] = append(c.Failed[kind], i)
+	}
+	if kind != kindProduct || i < im.resumed {
+		// Other records and retries of products leave the progress as it is
+		if err != nil {
+			return c.save()
+		}
+		return nil
+	}
+	return c.productDone(id)
+}
 
-				var noproducterr error
-				productId, noproducterr = getProductIdByProperty(key, keyValue, client)
+// importRecord looks up the resource an exported record belongs to and imports it.
+// Errors are reported as they occur and returned.
+func (im *importer) importRecord(kind string, i int, total int, record contentOutput, resolve func() (contentRef, error)) error {
+	progress := fmt.Sprintf("%d of %d", i, total)
+	if total == 0 {
+		// The number of records of a streamed file is unknown
+		progress = fmt.Sprintf("%d", i)
+	}
+	s := spin.New("  \033[36m Importing " + kind + " " + progress + "\033[m %s")
+	s.Set(spin.Spin1)
+	s.Start()
 
-				if noproducterr != nil {
-					fmt.Printf("Skipping %s:%s", key, keyValue)
-					continue
-				}
+	ref, err := resolve()
+	if err != nil {
+		s.Stop()
+		fmt.Printf("Skipping: %s\n", err)
+		return err
+	}
 
-				fmt.Printf("%s: %s => id: %d\n", key, keyValue, *productId)
+	plan, err := im.add(ref, record)
+	s.Stop()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't update %s: %s\n", ref, err)
+	}
+	if plan != nil {
+		plan.Print(os.Stdout)
+	}
+	return err
+}
 
-			} else {
-				productId = p.Id
-			}
+// add imports a record into the resource ref points to. In a dry run nothing is
+// written and the planned changes are returned instead.
+func (im *importer) add(ref contentRef, record contentOutput) (*ImportPlan, error) {
+	if im.dryRun {
+		plan, err := PlanImport(ref, record, im.store)
+		if err != nil {
+			return nil, err
+		}
+		im.plans = append(im.plans, plan)
+		return plan, nil
+	}
 
-			// Delete all metafields first because Shopify throws an error when creating a metafield
-			// with an existing key. It *should* just update it imho, but hey...
-			DeleteAllPowereditorMetafields(*productId, client)
-			metafields := AssembleMetafieldData(p.Fields, client)
-
-			// for _, mf := range metafields {
-			// 	fmt.Printf("METAFIELDS: %s, %s\n", *mf.Key, *mf.Namespace)
-			// }
-
-			// debug("Product id: %d", *productId)
-			// debug("Body: %s", *p.BodyHtml)
-			updatedProduct := &shopify.Product{
-				Id: productId,
-				// Handle:     p.Handle,
-				Title:                          p.Title,
-				BodyHtml:                       p.BodyHtml,
-				MetafieldsGlobalTitleTag:       p.MetafieldsGlobalTitleTag,
-				MetafieldsGlobalDescriptionTag: p.MetafieldsGlobalDescriptionTag,
-				Metafields:                     metafields,
-			}
+	// Never touch a resource whose current state could not be saved
+	if im.backup != nil {
+		if err := im.backup.add(ref, record, im.store); err != nil {
+			return nil, fmt.Errorf("backup failed: %s", err)
+		}
+	}
+	return nil, importContent(ref, record, im.store)
+}
 
-			_, err := client.Products.Edit(context.Background(), updatedProduct)
-			// debug("resp %s", resp)
-			if err != nil {
-				fmt.Errorf("%s", err)
-			}
-			s.Stop()
+// finish prints the summary of a dry run and removes the checkpoint of a completed import.
+// If records failed, the checkpoint is kept so they can be retried with --resume, and an
+// error is returned.
+func (im *importer) finish() error {
+	if im.dryRun {
+		printPlanSummary(os.Stdout, im.plans)
+	}
+	if im.backup != nil {
+		if err := im.backup.finish(); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't write backup: %v\n", err)
+			fmt.Println("   The backup is kept in", im.backup.journalName())
 		}
-	},
+	}
+	if im.failed > 0 {
+		if im.checkpoint != nil {
+			fmt.Println("   Run the same command with --resume to retry them")
+		}
+		return fmt.Errorf("%d records couldn't be imported", im.failed)
+	}
+	if im.checkpoint != nil {
+		if err := im.checkpoint.remove(); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't remove checkpoint: %v\n", err)
+		}
+	}
+	return nil
 }
 
-// DeleteAllPowereditorMetafields deletes all metafields in the power-editor namespace
-func DeleteAllPowereditorMetafields(productID int, client *shopify.Client) {
-	opt := &shopify.MetafieldListOptions{Namespace: viper.GetString("import.namespace")}
-	metafields, _, err := client.Metafields.ListByProduct(context.Background(), productID, opt)
+// resolveProductId returns the ID of the product in the store that the exported product
+// should be imported into, using the configured primary key.
+func resolveProductId(p *ProductOutput, store Store) (*int, error) {
+	key := viper.GetString("import.primary-key")
+	if !allowedPrimaryKeys[key] {
+		if p.Id == nil {
+			return nil, errors.New("product has no id")
+		}
+		return p.Id, nil
+	}
+
+	// Get the key (handle or title) as string
+	f := reflect.ValueOf(p).Elem().FieldByName(strings.Title(key))
+	keyValue := reflect.Indirect(f).String()
+
+	productId, err := getProductIdByProperty(key, keyValue, store)
 	if err != nil {
-		fmt.Errorf("%s", err)
+		return nil, err
 	}
-	for _, m := range metafields {
-		fmt.Printf("delete metafields: %s, %d\n", *m.Key, int64(*m.Id))
-		resp, err := client.Metafields.Delete(context.Background(), *m.Id)
-		if err != nil {
-			fmt.Errorf("%s - %s", err, resp.Body)
+	fmt.Printf("%s: %s => id: %d\n", key, keyValue, *productId)
+	return productId, nil
+}
+
+// resolvePageRef returns the page in the store that an exported page should be imported into
+func resolvePageRef(p *PageOutput, store Store) (contentRef, error) {
+	id, err := resolveContentId(p.content(), kindPage, 0, store)
+	return contentRef{kind: kindPage, id: id}, err
+}
+
+// resolveCollectionRef returns the collection in the store that an exported collection should be
+// imported into. Collections are only matched with collections of the same type.
+func resolveCollectionRef(c *CollectionOutput, store Store) (contentRef, error) {
+	kind := c.kind()
+	id, err := resolveContentId(c.content(), kind, 0, store)
+	return contentRef{kind: kind, id: id}, err
+}
+
+// resolveArticleRef returns the article in the store that an exported article should be imported
+// into. When matching by handle or title, the blog is looked up by its handle as well.
+func resolveArticleRef(a *ArticleOutput, store Store) (contentRef, error) {
+	if !allowedPrimaryKeys[viper.GetString("import.primary-key")] {
+		if a.Id == nil || a.BlogId == nil {
+			return contentRef{}, errors.New("article has no id")
 		}
+		return contentRef{kind: kindArticle, id: *a.Id, parentId: *a.BlogId}, nil
 	}
+
+	if a.BlogHandle == nil {
+		return contentRef{}, errors.New("article has no blog handle")
+	}
+	blogId, err := findContentId("blog", 0, "handle", *a.BlogHandle, store)
+	if err != nil {
+		return contentRef{}, err
+	}
+	id, err := resolveContentId(a.content(), kindArticle, blogId, store)
+	return contentRef{kind: kindArticle, id: id, parentId: blogId}, err
 }
 
-func AssembleMetafieldData(fields []*OutputField, client *shopify.Client) (metafields []*shopify.Metafield) {
+// resolveContentId returns the ID of the resource of a kind that an exported record should be
+// imported into, using the configured primary key. parentId is the blog of an article.
+func resolveContentId(c *content, kind string, parentId int, store Store) (int, error) {
+	key := viper.GetString("import.primary-key")
+	var keyValue *string
+	switch key {
+	case "handle":
+		keyValue = c.Handle
+	case "title":
+		keyValue = c.Title
+	default:
+		if c.Id == nil {
+			return 0, fmt.Errorf("%s record has no id", kind)
+		}
+		return *c.Id, nil
+	}
 
-	for _, field := range fields {
-		var rowsToMerge []string
-		for _, row := range field.Data {
-			colsToMerge := getSliceOfMapValue(row)
-			rowsToMerge = append(rowsToMerge, strings.Join(colsToMerge, colSeparator))
+	if keyValue == nil {
+		return 0, fmt.Errorf("%s record has no %s", kind, key)
+	}
+	id, err := findContentId(kind, parentId, key, *keyValue, store)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Printf("%s: %s => id: %d\n", key, *keyValue, id)
+	return id, nil
+}
+
+// importContent writes an exported record to the resource ref points to
+func importContent(ref contentRef, record contentOutput, store Store) error {
+	if ref.hasContent() && !viper.GetBool("import.metafields-only") {
+		if err := store.EditContent(ref, record.content()); err != nil {
+			return err
+		}
+	}
+	metafields := AssembleMetafieldData(record.outputFields())
+	if err := ReconcileMetafields(ref, metafields, viper.GetBool("import.prune"), store); err != nil {
+		return err
+	}
+	if p, ok := record.(*ProductOutput); ok {
+		return importVariants(ref.id, p.Variants, viper.GetBool("import.prune"), store)
+	}
+	return nil
+}
+
+// ReconcileMetafields brings the power-editor metafields of a resource in line with the given
+// ones. Changed values are updated in place and new keys are created. Keys that are missing
+// from the given metafields are only deleted if prune is set.
+func ReconcileMetafields(ref contentRef, metafields []*shopify.Metafield, prune bool, store Store) error {
+	namespace := viper.GetString("import.namespace")
+	existing, err := store.ListMetafields(ref, namespace)
+	if err != nil {
+		return err
+	}
+
+	var set, remove []*shopify.Metafield
+	for _, c := range diffMetafields(existing, metafields, prune) {
+		switch c.Action {
+		case actionCreate:
+			set = append(set, &shopify.Metafield{Key: c.desired.Key, Value: c.desired.Value})
+		case actionUpdate:
+			set = append(set, &shopify.Metafield{Id: c.existing.Id, Key: c.desired.Key, Value: c.desired.Value, ValueType: c.existing.ValueType})
+		case actionDelete:
+			fmt.Printf("delete metafield: %s, %d\n", *c.existing.Key, *c.existing.Id)
+			remove = append(remove, c.existing)
 		}
-		metafieldValue := strings.Join(rowsToMerge, rowSeparator)
+	}
+
+	var errorMsg []string
+	if len(set) > 0 {
+		if err := store.SetMetafields(ref, namespace, set); err != nil {
+			errorMsg = append(errorMsg, err.Error())
+		}
+	}
+	if len(remove) > 0 {
+		if err := store.DeleteMetafields(ref, remove); err != nil {
+			errorMsg = append(errorMsg, err.Error())
+		}
+	}
+	if len(errorMsg) > 0 {
+		return errors.New(strings.Join(errorMsg, ", "))
+	}
+	return nil
+}
+
+func AssembleMetafieldData(fields []*OutputField) (metafields []*shopify.Metafield) {
+
+	for _, field := range fields {
+		value := metafieldValue(field)
 
 		valueType := "string"
 		ns := viper.GetString("import.namespace")
@@ -170,7 +464,7 @@ func AssembleMetafieldData(fields []*OutputField, client *shopify.Client) (metaf
 			Namespace: &ns,
 			Key:       field.Key,
 			Id:        field.Id,
-			Value:     &metafieldValue,
+			Value:     &value,
 			ValueType: &valueType,
 		}
 
@@ -179,16 +473,24 @@ func AssembleMetafieldData(fields []*OutputField, client *shopify.Client) (metaf
 	return
 }
 
-func getProductIdByProperty(propertyName string, propertyValue string, client *shopify.Client) (*int, error) {
+// metafieldValue merges the rows and columns of an exported field into a metafield value
+func metafieldValue(field *OutputField) string {
+	var rowsToMerge []string
+	for _, colsToMerge := range field.Data {
+		rowsToMerge = append(rowsToMerge, strings.Join(colsToMerge, colSeparator))
+	}
+	return strings.Join(rowsToMerge, rowSeparator)
+}
+
+func getProductIdByProperty(propertyName string, propertyValue string, store Store) (*int, error) {
 
-	ctx := context.Background()
 	opt := &shopify.ProductListOptions{Fields: []string{"id", "metafields"}}
 
 	f := reflect.Indirect(reflect.ValueOf(opt)).FieldByName(strings.Title(propertyName))
 	f.SetString(propertyValue)
 
 	// if there is no ID look up products by handle or title
-	products, _, err := client.Products.List(ctx, opt)
+	products, err := store.ListProducts(opt)
 	if err != nil {
 		return nil, fmt.Errorf("Can't find product with %s '%s': %s'", propertyName, propertyValue, err)
 	}
@@ -196,7 +498,7 @@ func getProductIdByProperty(propertyName string, propertyValue string, client *s
 		return nil, fmt.Errorf("Found more than on product for %s '%s': %s'", propertyName, propertyValue, err)
 	}
 	if len(products) == 0 {
-		return nil, fmt.Errorf("Found no product  '%s': %s'", propertyName, propertyValue, err)
+		return nil, fmt.Errorf("Found no product with %s '%s'", propertyName, propertyValue)
 	}
 	//spew.Dump(products[0])
 	return products[0].Id, nil
@@ -206,8 +508,11 @@ func init() {
 	allowedPrimaryKeys = map[string]bool{"handle": true, "title": true}
 	RootCmd.AddCommand(importCmd)
 	importCmd.Flags().BoolP("metafields-only", "m", false, "Don't import product titles or descriptions")
-	// importCmd.Flags().BoolP("dry-run", "d", false, "Do not import but show a list of updates that would happen")
+	importCmd.Flags().BoolP("dry-run", "d", false, "Do not import but show a list of updates that would happen")
+	importCmd.Flags().Bool("prune", false, "Delete metafields in the namespace that are not in the data file")
+	importCmd.Flags().String("backup-dir", ".", "the directory the pre-import backup is written to")
+	importCmd.Flags().Bool("no-backup", false, "Don't write a backup of the products before importing")
+	importCmd.Flags().Bool("resume", false, "skip the products imported by an interrupted import of the same file")
 	importCmd.Flags().StringP("primary-key", "1", "id", `Possible values are "id", "handle" and "title"`)
-	viper.BindPFlag("import.primary-key", importCmd.Flags().Lookup("primary-key"))
-	viper.BindPFlag("import.metafields-only", importCmd.Flags().Lookup("metafields-only"))
+	importCmd.Flags().String("format", "", "the file format of the data file: "+strings.Join(formatNames(), ", ")+" (default is told by its extension)")
 }
@@ -0,0 +1,372 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The directory layout writes every record to a file of its own, so changes to the content
+// of a store can be reviewed file by file. The manifest tells which files make up the data.
+const manifestName = "manifest"
+
+// Manifest lists the record files of a directory, relative to it, in the order of the export
+type Manifest struct {
+	Header      *Header  `json:"header"`
+	Products    []string `json:"products,omitempty"`
+	Pages       []string `json:"pages,omitempty"`
+	Articles    []string `json:"articles,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+	Shop        string   `json:"shop,omitempty"`
+	HtmlFiles   []string `json:"html_files,omitempty"`
+}
+
+// recordEncoding encodes single records in the directory layout
+type recordEncoding struct {
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(b []byte, v interface{}) error
+}
+
+// recordEncodings are the file formats supported by the directory layout
+var recordEncodings = map[string]*recordEncoding{
+	"json": {
+		marshal:   func(v interface{}) ([]byte, error) { return JSONMarshalIndent(v, "", "  ") },
+		unmarshal: json.Unmarshal,
+	},
+	"yaml": {marshal: marshalYAML, unmarshal: unmarshalYAML},
+	"toml": {marshal: marshalTOML, unmarshal: unmarshalTOML},
+}
+
+// htmlFileRef is the value of a cell whose HTML has been moved to a file of its own
+var htmlFileRef = regexp.MustCompile(`^<!--\|file:(.+)\|-->$`)
+
+// htmlPattern matches text that contains markup
+var htmlPattern = regexp.MustCompile(`<[a-zA-Z/!]`)
+
+// directoryWriter writes a data file in the directory layout. The first error
+// stops all further writes and is kept in err.
+type directoryWriter struct {
+	dir       string
+	format    string
+	splitHtml bool
+	manifest  Manifest
+	err       error
+}
+
+// writeDirectory writes data to dir, with a file per record in the given format. Files listed
+// by a previous manifest in dir are removed first, so deleted records don't linger. With
+// splitHtml, HTML cells are moved to files of their own and replaced by a reference.
+func writeDirectory(output *Output, dir string, format string, splitHtml bool) error {
+	if recordEncodings[format] == nil {
+		return fmt.Errorf("the dir layout doesn't support the %s format", format)
+	}
+	if err := removeManifestFiles(dir); err != nil {
+		return err
+	}
+
+	w := &directoryWriter{dir: dir, format: format, splitHtml: splitHtml}
+	w.manifest.Header = output.Header
+	if w.manifest.Header == nil {
+		w.manifest.Header = &Header{}
+	}
+	w.manifest.Header.FormatVersion = FormatVersion
+
+	for _, p := range output.Products {
+		base := path.Join("products", fileKey(p.Handle, p.Id))
+		p.BodyHtml = w.html(base, "body_html", p.BodyHtml)
+		w.fields(base, p.Fields)
+		for _, v := range p.Variants {
+			w.fields(path.Join(base, "variants", fileKey(v.Sku, v.Id)), v.Fields)
+		}
+		w.manifest.Products = append(w.manifest.Products, w.write(base, p))
+	}
+	for _, p := range output.Pages {
+		base := path.Join("pages", fileKey(p.Handle, p.Id))
+		p.BodyHtml = w.html(base, "body_html", p.BodyHtml)
+		w.fields(base, p.Fields)
+		w.manifest.Pages = append(w.manifest.Pages, w.write(base, p))
+	}
+	for _, a := range output.Articles {
+		base := path.Join("articles", fileKey(a.BlogHandle, a.BlogId), fileKey(a.Handle, a.Id))
+		a.BodyHtml = w.html(base, "body_html", a.BodyHtml)
+		w.fields(base, a.Fields)
+		w.manifest.Articles = append(w.manifest.Articles, w.write(base, a))
+	}
+	for _, c := range output.Collections {
+		base := path.Join("collections", fileKey(c.Handle, c.Id))
+		c.BodyHtml = w.html(base, "body_html", c.BodyHtml)
+		w.fields(base, c.Fields)
+		w.manifest.Collections = append(w.manifest.Collections, w.write(base, c))
+	}
+	if output.Shop != nil {
+		w.fields("shop", output.Shop.Fields)
+		w.manifest.Shop = w.write("shop", output.Shop)
+	}
+
+	w.write(manifestName, &w.manifest)
+	return w.err
+}
+
+// fileKey returns the name of a record's file: its handle (or SKU), or its id if it has none
+func fileKey(handle *string, id *int) string {
+	if handle != nil && *handle != "" {
+		return strings.Replace(*handle, "/", "_", -1)
+	}
+	return formatIntPtr(id)
+}
+
+// write encodes v to the file base plus the extension of the format and returns its name
+func (w *directoryWriter) write(base string, v interface{}) string {
+	file := base + "." + w.format
+	if w.err != nil {
+		return file
+	}
+	b, err := recordEncodings[w.format].marshal(v)
+	if err != nil {
+		w.err = err
+		return file
+	}
+	w.writeFile(file, b)
+	return file
+}
+
+func (w *directoryWriter) writeFile(file string, b []byte) {
+	if w.err != nil {
+		return
+	}
+	name := filepath.Join(w.dir, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		w.err = err
+		return
+	}
+	w.err = ioutil.WriteFile(name, b, 0644)
+}
+
+// html moves the HTML of a cell to the file base/name.html and returns the reference to it.
+// Cells without markup are returned as they are.
+func (w *directoryWriter) html(base string, name string, value *string) *string {
+	if !w.splitHtml || value == nil || !htmlPattern.MatchString(*value) {
+		return value
+	}
+	file := path.Join(base, name+".html")
+	w.writeFile(file, []byte(*value))
+	w.manifest.HtmlFiles = append(w.manifest.HtmlFiles, file)
+	ref := "<!--|file:" + file + "|-->"
+	return &ref
+}
+
+// fields moves the HTML cells of fields to files named after their key, row and column
+func (w *directoryWriter) fields(base string, fields []*OutputField) {
+	for _, f := range fields {
+		for i, row := range f.Data {
+			for j := range row {
+				name := fmt.Sprintf("%s-%d-%d", stringValue(f.Key), i, j)
+				row[j] = *w.html(base, name, &row[j])
+			}
+		}
+	}
+}
+
+// readManifest reads the manifest of dir, in any of the record formats, and returns its file
+// name. A directory with manifests in several formats is refused, as it's unclear which is current.
+func readManifest(dir string) (*Manifest, *recordEncoding, string, error) {
+	var names []string
+	for format := range recordEncodings {
+		name := manifestName + "." + format
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			names = append(names, name)
+		} else if !os.IsNotExist(err) {
+			return nil, nil, "", err
+		}
+	}
+	switch len(names) {
+	case 0:
+		return nil, nil, "", os.ErrNotExist
+	case 1:
+	default:
+		sort.Strings(names)
+		return nil, nil, "", fmt.Errorf("%s has more than one manifest (%s), remove all but the current one", dir, strings.Join(names, ", "))
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, names[0]))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	encoding := recordEncodings[strings.TrimPrefix(filepath.Ext(names[0]), ".")]
+	var m Manifest
+	if err := encoding.unmarshal(b, &m); err != nil {
+		return nil, nil, "", fmt.Errorf("%s: %v", names[0], err)
+	}
+	return &m, encoding, names[0], nil
+}
+
+// removeManifestFiles removes the manifest of dir and the files it lists, if there is one.
+// The next export may be in another format, whose manifest has another name.
+func removeManifestFiles(dir string) error {
+	m, _, manifestFile, err := readManifest(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	files := append(append(append(append(m.Products, m.Pages...), m.Articles...), m.Collections...), m.HtmlFiles...)
+	if m.Shop != "" {
+		files = append(files, m.Shop)
+	}
+	for _, file := range append(files, manifestFile) {
+		name, err := dataFilePath(dir, file)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataFilePath returns the path of a file listed by the manifest of dir. Files outside of dir are refused.
+func dataFilePath(dir string, file string) (string, error) {
+	clean := path.Clean("/" + file)
+	if clean != "/"+file {
+		return "", fmt.Errorf("file '%s' is outside of %s", file, dir)
+	}
+	return filepath.Join(dir, filepath.FromSlash(file)), nil
+}
+
+// readDirectory reads data written by writeDirectory. The HTML files that cells refer to are read back in.
+func readDirectory(dir string) (*Output, error) {
+	m, encoding, _, err := readManifest(dir)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s has no manifest", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Header == nil || m.Header.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("%s is not in format version %d, export it again", dir, FormatVersion)
+	}
+
+	r := &directoryReader{dir: dir, encoding: encoding}
+	output := &Output{Header: m.Header}
+	for _, file := range m.Products {
+		p := &ProductOutput{}
+		r.read(file, p)
+		p.BodyHtml = r.html(p.BodyHtml)
+		r.fields(p.Fields)
+		for _, v := range p.Variants {
+			r.fields(v.Fields)
+		}
+		output.Products = append(output.Products, p)
+	}
+	for _, file := range m.Pages {
+		p := &PageOutput{}
+		r.read(file, p)
+		p.BodyHtml = r.html(p.BodyHtml)
+		r.fields(p.Fields)
+		output.Pages = append(output.Pages, p)
+	}
+	for _, file := range m.Articles {
+		a := &ArticleOutput{}
+		r.read(file, a)
+		a.BodyHtml = r.html(a.BodyHtml)
+		r.fields(a.Fields)
+		output.Articles = append(output.Articles, a)
+	}
+	for _, file := range m.Collections {
+		c := &CollectionOutput{}
+		r.read(file, c)
+		c.BodyHtml = r.html(c.BodyHtml)
+		r.fields(c.Fields)
+		output.Collections = append(output.Collections, c)
+	}
+	if m.Shop != "" {
+		output.Shop = &ShopOutput{}
+		r.read(m.Shop, output.Shop)
+		r.fields(output.Shop.Fields)
+	}
+	return output, r.err
+}
+
+// directoryReader reads the files of a directory. The first error stops all further reads and is kept in err.
+type directoryReader struct {
+	dir      string
+	encoding *recordEncoding
+	err      error
+}
+
+func (r *directoryReader) readFile(file string) []byte {
+	if r.err != nil {
+		return nil
+	}
+	name, err := dataFilePath(r.dir, file)
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		r.err = err
+	}
+	return b
+}
+
+func (r *directoryReader) read(file string, v interface{}) {
+	b := r.readFile(file)
+	if r.err != nil {
+		return
+	}
+	if err := r.encoding.unmarshal(b, v); err != nil {
+		r.err = fmt.Errorf("%s: %v", file, err)
+	}
+}
+
+// html returns the content of the file a cell refers to, or the cell itself
+func (r *directoryReader) html(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	m := htmlFileRef.FindStringSubmatch(*value)
+	if m == nil {
+		return value
+	}
+	html := string(r.readFile(m[1]))
+	return &html
+}
+
+func (r *directoryReader) fields(fields []*OutputField) {
+	for _, f := range fields {
+		for _, row := range f.Data {
+			for j := range row {
+				row[j] = *r.html(&row[j])
+			}
+		}
+	}
+}
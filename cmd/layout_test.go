@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirectoryRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "powereditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := writeDirectory(testOutput(), dir, "json", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{
+		"manifest.json",
+		"products/blackroll-med-45.json",
+		"products/blackroll-med-45/body_html.html",
+		"products/blackroll-med-45/accordion-11-1.html",
+		"articles/news/hello.json",
+		"collections/rollen.json",
+		"shop.json",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("%s wasn't written: %v", file, err)
+		}
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "products/blackroll-med-45.json"))
+	if !strings.Contains(string(b), `"body_html": "<!--|file:products/blackroll-med-45/body_html.html|-->"`) {
+		t.Errorf("body_html doesn't refer to its file:\n%s", b)
+	}
+
+	got, err := readDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameOutput(t, got, testOutput())
+
+	// Files of records that are gone are removed by the next export
+	output := testOutput()
+	output.Products = nil
+	if err := writeDirectory(output, dir, "json", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"products/blackroll-med-45.json", "products/blackroll-med-45/body_html.html"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't removed", file)
+		}
+	}
+}
+
+func TestDirectoryChangesFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "powereditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := writeDirectory(testOutput(), dir, "json", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDirectory(testOutput(), dir, "yaml", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"manifest.json", "products/blackroll-med-45.json"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't removed", file)
+		}
+	}
+	got, err := readDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameOutput(t, got, testOutput())
+
+	// With two manifests it's unclear which one is current
+	if err := ioutil.WriteFile(filepath.Join(dir, "manifest.toml"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readDirectory(dir); err == nil || !strings.Contains(err.Error(), "manifest.toml, manifest.yaml") {
+		t.Errorf("got %v, want an error naming both manifests", err)
+	}
+}
+
+func TestDataFilePath(t *testing.T) {
+	for _, file := range []string{"../secret.html", "/etc/passwd", "products/../../x.json"} {
+		if _, err := dataFilePath("content", file); err == nil {
+			t.Errorf("%s: expected an error", file)
+		}
+	}
+}
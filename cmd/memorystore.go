@@ -0,0 +1,449 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dommmel/goshopping/shopify"
+)
+
+// memoryStore is a store that keeps its resources in memory. It behaves like the REST API
+// as far as the commands are concerned, so exports and imports can run without a shop.
+type memoryStore struct {
+	mu       sync.Mutex
+	name     string
+	products []*shopify.Product
+	// collects holds the product IDs of each collection
+	collects map[int][]int
+	// contents holds the pages, blogs, articles and collections by kind
+	contents map[string][]*memoryContent
+	// metafields holds the metafields by the owner path of their resource
+	metafields map[string][]*shopify.Metafield
+	lastId     int
+}
+
+// memoryContent is a page, blog, article or collection of a memoryStore
+type memoryContent struct {
+	content
+	parentId int
+}
+
+// newMemoryStore returns an empty store with the given domain
+func newMemoryStore(name string) *memoryStore {
+	return &memoryStore{
+		name:       name,
+		collects:   make(map[int][]int),
+		contents:   make(map[string][]*memoryContent),
+		metafields: make(map[string][]*shopify.Metafield),
+	}
+}
+
+// newId returns an ID that isn't used by any resource of the store yet
+func (s *memoryStore) newId() *int {
+	s.lastId++
+	id := s.lastId
+	return &id
+}
+
+// useId makes sure that IDs given to resources from the outside are never handed out again
+func (s *memoryStore) useId(id *int) *int {
+	if id == nil {
+		return s.newId()
+	}
+	if *id > s.lastId {
+		s.lastId = *id
+	}
+	return id
+}
+
+// addProduct adds a product and its variants to the store and to the given collections
+func (s *memoryStore) addProduct(p *shopify.Product, collectionIds ...int) *shopify.Product {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Id = s.useId(p.Id)
+	for _, v := range p.Variants {
+		v.Id = s.useId(v.Id)
+	}
+	s.products = append(s.products, p)
+	for _, id := range collectionIds {
+		s.collects[id] = append(s.collects[id], *p.Id)
+	}
+	return p
+}
+
+// addContent adds a page, blog, article or collection to the store. parentId is the blog of an article.
+func (s *memoryStore) addContent(kind string, parentId int, c *content) *content {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Id = s.useId(c.Id)
+	s.contents[kind] = append(s.contents[kind], &memoryContent{content: *c, parentId: parentId})
+	return c
+}
+
+// addToCollection adds products to a collection of the store
+func (s *memoryStore) addToCollection(collectionId int, productIds ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collects[collectionId] = append(s.collects[collectionId], productIds...)
+}
+
+// addMetafield attaches a metafield to a resource of the store
+func (s *memoryStore) addMetafield(ref contentRef, namespace string, key string, value string) *shopify.Metafield {
+	return s.attachMetafield(ref, &shopify.Metafield{Namespace: &namespace, Key: &key, Value: &value})
+}
+
+// attachMetafield attaches a metafield to a resource of the store, keeping its ID if it has one
+func (s *memoryStore) attachMetafield(ref contentRef, m *shopify.Metafield) *shopify.Metafield {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.Id = s.useId(m.Id)
+	s.metafields[ref.owner()] = append(s.metafields[ref.owner()], m)
+	return m
+}
+
+func (s *memoryStore) Name() string {
+	return s.name
+}
+
+// ListProducts supports the options the commands use. Like the REST API it only returns
+// the properties listed in opt.Fields.
+func (s *memoryStore) ListProducts(opt *shopify.ProductListOptions) ([]*shopify.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if opt == nil {
+		opt = &shopify.ProductListOptions{}
+	}
+	var list []*shopify.Product
+	for _, p := range s.products {
+		if !s.productMatches(p, opt) {
+			continue
+		}
+		var out *shopify.Product
+		if err := copyFields(p, &out, opt.Fields); err != nil {
+			return nil, err
+		}
+		list = append(list, out)
+	}
+	return list, nil
+}
+
+// productMatches tells if a product passes the filters of the list options
+func (s *memoryStore) productMatches(p *shopify.Product, opt *shopify.ProductListOptions) bool {
+	if len(opt.Ids) > 0 && !containsInt(opt.Ids, *p.Id) {
+		return false
+	}
+	if opt.SinceId != 0 && *p.Id <= opt.SinceId {
+		return false
+	}
+	if opt.Handle != "" && (p.Handle == nil || !containsString(strings.Split(opt.Handle, ","), *p.Handle)) {
+		return false
+	}
+	if opt.Title != "" && (p.Title == nil || *p.Title != opt.Title) {
+		return false
+	}
+	if opt.Vendor != "" && (p.Vendor == nil || *p.Vendor != opt.Vendor) {
+		return false
+	}
+	if opt.ProductType != "" && (p.ProductType == nil || *p.ProductType != opt.ProductType) {
+		return false
+	}
+	if !opt.UpdatedAtMin.IsZero() && (p.UpdatedAt == nil || p.UpdatedAt.Before(opt.UpdatedAtMin)) {
+		return false
+	}
+	if opt.CollectionId != 0 && !containsInt(s.collects[opt.CollectionId], *p.Id) {
+		return false
+	}
+	return true
+}
+
+func (s *memoryStore) ListContent(kind string, parentId int, opt *contentListOptions) ([]*content, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if opt == nil {
+		opt = &contentListOptions{}
+	}
+	var list []*content
+	for _, c := range s.contents[kind] {
+		if kind == kindArticle && c.parentId != parentId {
+			continue
+		}
+		if opt.Handle != "" && (c.Handle == nil || *c.Handle != opt.Handle) {
+			continue
+		}
+		if opt.Title != "" && (c.Title == nil || *c.Title != opt.Title) {
+			continue
+		}
+		var out *content
+		if err := copyFields(&c.content, &out, opt.Fields); err != nil {
+			return nil, err
+		}
+		list = append(list, out)
+	}
+	return list, nil
+}
+
+func (s *memoryStore) GetContent(ref contentRef) (*content, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ref.kind == kindProduct {
+		p := s.findProduct(ref.id)
+		if p == nil {
+			return nil, fmt.Errorf("Found no %s", ref)
+		}
+		return &content{Id: p.Id, Handle: p.Handle, Title: p.Title, BodyHtml: p.BodyHtml}, nil
+	}
+	c := s.findContent(ref)
+	if c == nil {
+		return nil, fmt.Errorf("Found no %s", ref)
+	}
+	out := c.content
+	return &out, nil
+}
+
+// EditContent stores the SEO tags as global metafields, like the REST API does
+func (s *memoryStore) EditContent(ref contentRef, c *content) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ref.kind == kindProduct {
+		p := s.findProduct(ref.id)
+		if p == nil {
+			return fmt.Errorf("Found no %s", ref)
+		}
+		p.Title, p.BodyHtml = pickString(c.Title, p.Title), pickString(c.BodyHtml, p.BodyHtml)
+	} else {
+		current := s.findContent(ref)
+		if current == nil {
+			return fmt.Errorf("Found no %s", ref)
+		}
+		current.Title, current.BodyHtml = pickString(c.Title, current.Title), pickString(c.BodyHtml, current.BodyHtml)
+	}
+	s.setGlobal(ref, "title_tag", c.MetafieldsGlobalTitleTag)
+	s.setGlobal(ref, "description_tag", c.MetafieldsGlobalDescriptionTag)
+	return nil
+}
+
+// setGlobal sets the value of a global metafield of a resource, unless value is nil
+func (s *memoryStore) setGlobal(ref contentRef, key string, value *string) {
+	if value == nil {
+		return
+	}
+	for _, m := range s.metafields[ref.owner()] {
+		if *m.Namespace == "global" && *m.Key == key {
+			m.Value = copyString(value)
+			return
+		}
+	}
+	namespace := "global"
+	m := &shopify.Metafield{Id: s.newId(), Namespace: &namespace, Key: &key, Value: copyString(value)}
+	s.metafields[ref.owner()] = append(s.metafields[ref.owner()], m)
+}
+
+// ListMetafields returns the metafields of all namespaces if namespace is empty
+func (s *memoryStore) ListMetafields(ref contentRef, namespace string) ([]*shopify.Metafield, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []*shopify.Metafield
+	for _, m := range s.metafields[ref.owner()] {
+		if namespace == "" || *m.Namespace == namespace {
+			out := *m
+			list = append(list, &out)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryStore) SetMetafields(ref contentRef, namespace string, metafields []*shopify.Metafield) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owner := ref.owner()
+	for _, m := range metafields {
+		if m.Id == nil {
+			for _, existing := range s.metafields[owner] {
+				if *existing.Namespace == namespace && *existing.Key == *m.Key {
+					return fmt.Errorf("metafield %s: key must be unique within this namespace", *m.Key)
+				}
+			}
+			out := &shopify.Metafield{Id: s.newId(), Namespace: &namespace, Key: m.Key, Value: copyString(m.Value), ValueType: m.ValueType}
+			s.metafields[owner] = append(s.metafields[owner], out)
+			continue
+		}
+		existing := findMetafield(s.metafields[owner], *m.Id)
+		if existing == nil {
+			return fmt.Errorf("metafield %s: Found no metafield %d of %s", *m.Key, *m.Id, ref)
+		}
+		existing.Value = copyString(m.Value)
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteMetafields(ref contentRef, metafields []*shopify.Metafield) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owner := ref.owner()
+	for _, m := range metafields {
+		list := s.metafields[owner]
+		kept := list[:0]
+		for _, existing := range list {
+			if *existing.Id != *m.Id {
+				kept = append(kept, existing)
+			}
+		}
+		if len(kept) == len(list) {
+			return fmt.Errorf("metafield %s: Found no metafield %d of %s", *m.Key, *m.Id, ref)
+		}
+		s.metafields[owner] = kept
+	}
+	return nil
+}
+
+// removeMetafield deletes the metafield with the given ID from whatever resource it is attached to
+func (s *memoryStore) removeMetafield(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for owner, list := range s.metafields {
+		for i, m := range list {
+			if *m.Id == id {
+				s.metafields[owner] = append(list[:i:i], list[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exists tells if the resource ref points to is in the store
+func (s *memoryStore) exists(ref contentRef) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch ref.kind {
+	case kindShop:
+		return true
+	case kindProduct:
+		return s.findProduct(ref.id) != nil
+	case kindVariant:
+		if p := s.findProduct(ref.parentId); p != nil {
+			for _, v := range p.Variants {
+				if *v.Id == ref.id {
+					return true
+				}
+			}
+		}
+		return false
+	case kindCustomCollection, kindSmartCollection:
+		// Both kinds share their metafield endpoints, so either will do
+		return s.findContent(contentRef{kind: kindCustomCollection, id: ref.id}) != nil ||
+			s.findContent(contentRef{kind: kindSmartCollection, id: ref.id}) != nil
+	}
+	return s.findContent(ref) != nil
+}
+
+// findProduct returns the product with the given ID or nil
+func (s *memoryStore) findProduct(id int) *shopify.Product {
+	for _, p := range s.products {
+		if *p.Id == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// findContent returns the page, blog, article or collection ref points to or nil
+func (s *memoryStore) findContent(ref contentRef) *memoryContent {
+	for _, c := range s.contents[ref.kind] {
+		if *c.Id == ref.id && (ref.kind != kindArticle || c.parentId == ref.parentId) {
+			return c
+		}
+	}
+	return nil
+}
+
+// findMetafield returns the metafield with the given ID or nil
+func findMetafield(metafields []*shopify.Metafield, id int) *shopify.Metafield {
+	for _, m := range metafields {
+		if *m.Id == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// copyFields copies the JSON properties of src that are listed in fields to a new value at
+// dst, or all properties if fields is empty. Nothing of the copy is shared with src.
+func copyFields(src interface{}, dst interface{}, fields []string) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(b, &all); err != nil {
+			return err
+		}
+		selected := make(map[string]json.RawMessage)
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				selected[f] = v
+			}
+		}
+		if b, err = json.Marshal(selected); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// pickString returns a copy of value, or current if value is nil
+func pickString(value *string, current *string) *string {
+	if value == nil {
+		return current
+	}
+	return copyString(value)
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+func containsInt(list []int, i int) bool {
+	for _, x := range list {
+		if x == i {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
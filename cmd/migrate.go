@@ -0,0 +1,215 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// migrations upgrade a decoded data file from the version they are indexed by to the next one
+var migrations = map[int]func(doc map[string]interface{}) error{
+	1: migrateV1,
+	2: migrateV2,
+}
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate <data file>",
+	Short: "Upgrade a data file written by an older version to the current format",
+	Long: `Upgrade a data file written by an older version to the current format.
+The file is rewritten in place, unless another file is given with --output.`,
+
+	// Do all the error handling pre run
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		var errorMsg []string
+		if len(args) < 1 {
+			errorMsg = append(errorMsg, "path to data file required as an argument")
+		} else if _, err := os.Stat(args[0]); os.IsNotExist(err) {
+			errorMsg = append(errorMsg, fmt.Sprintf("Can't access file. %v", err))
+		}
+		return requiredFlagsError(errorMsg)
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, version, err := migrateFile(args[0])
+		if err != nil {
+			return err
+		}
+
+		target := args[0]
+		if cmd.Flags().Changed("output") {
+			target = outputFile
+		}
+		if err := writeOutput(data, target); err != nil {
+			return err
+		}
+		fmt.Printf("== Migrated %s from version %d to %d\n", target, version, FormatVersion)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(migrateCmd)
+}
+
+// jsonConverters turn data files of the formats that are converted from and to JSON into JSON,
+// so older versions of them can be migrated like JSON files
+var jsonConverters = map[string]func(b []byte) ([]byte, error){
+	"json": func(b []byte) ([]byte, error) { return b, nil },
+	"yaml": yamlToJSON,
+	"toml": tomlToJSON,
+}
+
+// migrateFile reads a data file of any supported format, upgrades it to the current format
+// version and returns the version it was written in. The formats other than JSON, YAML and
+// TOML were added in version 3 and are only read in the current version.
+func migrateFile(fileName string) (*Output, int, error) {
+	format := formatOf(fileName)
+	toJSON := jsonConverters[format]
+	if info, err := os.Stat(fileName); toJSON == nil || (err == nil && info.IsDir()) {
+		data, err := readOutputAs(fileName, format)
+		if err != nil {
+			return nil, 0, err
+		}
+		if data.Header == nil {
+			return data, FormatVersion, nil
+		}
+		return data, data.Header.FormatVersion, nil
+	}
+
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if b, err = toJSON(b); err != nil {
+		return nil, 0, fmt.Errorf("can't read %s as %s: %v", fileName, format, err)
+	}
+	return decodeOutput(b)
+}
+
+// decodeOutput upgrades a data file to the current format version and decodes it.
+// It returns the version the file was written in.
+func decodeOutput(b []byte) (*Output, int, error) {
+	var doc map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return nil, 0, err
+	}
+
+	version := formatVersionOf(doc)
+	if version > FormatVersion {
+		return nil, version, fmt.Errorf("format version %d is newer than this tool supports (%d)", version, FormatVersion)
+	}
+	for v := version; v < FormatVersion; v++ {
+		if err := migrations[v](doc); err != nil {
+			return nil, version, fmt.Errorf("can't migrate from version %d: %v", v, err)
+		}
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, version, err
+	}
+	var data Output
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, version, err
+	}
+	return &data, version, nil
+}
+
+// formatVersionOf returns the format version of a decoded data file.
+// Version 1 files don't carry a version, version 2 files have it at the top level.
+func formatVersionOf(doc map[string]interface{}) int {
+	version := doc["format_version"]
+	if header, ok := doc["header"].(map[string]interface{}); ok {
+		version = header["format_version"]
+	}
+	if n, ok := version.(json.Number); ok {
+		if v, err := n.Int64(); err == nil {
+			return int(v)
+		}
+	}
+	return 1
+}
+
+// migrateV1 turns the rows and columns of all fields from objects keyed by "0", "1", ... into arrays
+func migrateV1(doc map[string]interface{}) error {
+	err := eachField(doc, func(field map[string]interface{}) error {
+		b, err := json.Marshal(field["data"])
+		if err != nil {
+			return err
+		}
+		var data FieldData
+		if err := json.Unmarshal(b, &data); err != nil {
+			return err
+		}
+		field["data"] = data
+		return nil
+	})
+	doc["format_version"] = 2
+	return err
+}
+
+// migrateV2 moves the format version into the header
+func migrateV2(doc map[string]interface{}) error {
+	delete(doc, "format_version")
+	doc["header"] = map[string]interface{}{"format_version": 3}
+	return nil
+}
+
+// eachField calls fn for every field of every record (and variant) in a decoded data file
+func eachField(doc map[string]interface{}, fn func(field map[string]interface{}) error) error {
+	var records []interface{}
+	for _, kind := range []string{"products", "pages", "articles", "collections"} {
+		list, _ := doc[kind].([]interface{})
+		records = append(records, list...)
+	}
+	if shop, ok := doc["shop"]; ok {
+		records = append(records, shop)
+	}
+
+	for i := 0; i < len(records); i++ {
+		record, ok := records[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// Variants have fields of their own
+		variants, _ := record["variants"].([]interface{})
+		records = append(records, variants...)
+
+		fields, _ := record["fields"].([]interface{})
+		for _, f := range fields {
+			if field, ok := f.(map[string]interface{}); ok {
+				if err := fn(field); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
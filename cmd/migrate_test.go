@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeOutput(t *testing.T) {
+	files := map[int]string{
+		1: `{"products": [{"id": 117563710, "fields": [{"key": "tabs", "data": {"0": {"0": "a", "1": "b"}, "1": {"0": "c"}}}],
+			"variants": [{"sku": "BR-45", "fields": [{"key": "size", "data": {"0": {"0": "45 cm"}}}]}]}],
+			"shop": {"fields": [{"key": "banner", "data": {"0": {"0": "sale"}}}]}}`,
+		2: `{"format_version": 2, "products": [{"id": 117563710, "fields": [{"key": "tabs", "data": [["a", "b"], ["c"]]}],
+			"variants": [{"sku": "BR-45", "fields": [{"key": "size", "data": [["45 cm"]]}]}]}],
+			"shop": {"fields": [{"key": "banner", "data": [["sale"]]}]}}`,
+		3: `{"header": {"format_version": 3, "store": "my-first-store.myshopify.com"},
+			"products": [{"id": 117563710, "fields": [{"key": "tabs", "data": [["a", "b"], ["c"]]}],
+			"variants": [{"sku": "BR-45", "fields": [{"key": "size", "data": [["45 cm"]]}]}]}],
+			"shop": {"fields": [{"key": "banner", "data": [["sale"]]}]}}`,
+	}
+
+	for version, file := range files {
+		data, from, err := decodeOutput([]byte(file))
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if from != version {
+			t.Errorf("version %d: detected version %d", version, from)
+		}
+		if data.Header == nil || data.Header.FormatVersion != FormatVersion {
+			t.Errorf("version %d: header not migrated: %+v", version, data.Header)
+		}
+		p := data.Products[0]
+		if *p.Id != 117563710 {
+			t.Errorf("version %d: got id %d", version, *p.Id)
+		}
+		if want := (FieldData{{"a", "b"}, {"c"}}); !reflect.DeepEqual(p.Fields[0].Data, want) {
+			t.Errorf("version %d: got product data %v, want %v", version, p.Fields[0].Data, want)
+		}
+		if want := (FieldData{{"45 cm"}}); !reflect.DeepEqual(p.Variants[0].Fields[0].Data, want) {
+			t.Errorf("version %d: got variant data %v, want %v", version, p.Variants[0].Fields[0].Data, want)
+		}
+		if want := (FieldData{{"sale"}}); !reflect.DeepEqual(data.Shop.Fields[0].Data, want) {
+			t.Errorf("version %d: got shop data %v, want %v", version, data.Shop.Fields[0].Data, want)
+		}
+	}
+
+	if _, _, err := decodeOutput([]byte(`{"header": {"format_version": 99}}`)); err == nil {
+		t.Error("expected an error for a newer format version")
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "powereditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"v1.yaml": `products:
+- id: 117563710
+  fields:
+  - key: tabs
+    data: {"0": {"0": a, "1": b}, "1": {"0": c}}
+`,
+		"v2.toml": `format_version = 2
+
+[[products]]
+id = 117563710
+
+[[products.fields]]
+key = "tabs"
+data = [["a", "b"], ["c"]]
+`,
+	}
+	for name, file := range files {
+		fileName := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fileName, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		data, from, err := migrateFile(fileName)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want := int(name[1] - '0'); from != want {
+			t.Errorf("%s: detected version %d, want %d", name, from, want)
+		}
+		if data.Header == nil || data.Header.FormatVersion != FormatVersion {
+			t.Errorf("%s: header not migrated: %+v", name, data.Header)
+		}
+		if want := (FieldData{{"a", "b"}, {"c"}}); !reflect.DeepEqual(data.Products[0].Fields[0].Data, want) {
+			t.Errorf("%s: got data %v, want %v", name, data.Products[0].Fields[0].Data, want)
+		}
+	}
+
+	// NDJSON files were introduced with the current version and can't be migrated
+	fileName := filepath.Join(dir, "v2.ndjson")
+	if err := ioutil.WriteFile(fileName, []byte(`{"header": {"format_version": 2}}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := migrateFile(fileName); err == nil {
+		t.Error("expected an error for an NDJSON file of an older version")
+	}
+}
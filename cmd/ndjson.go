@@ -0,0 +1,401 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/viper"
+)
+
+// ndjsonRecord is a single line of an NDJSON data file. The first line holds the header,
+// each following line exactly one record.
+type ndjsonRecord struct {
+	Header     *Header           `json:"header,omitempty"`
+	Product    *ProductOutput    `json:"product,omitempty"`
+	Page       *PageOutput       `json:"page,omitempty"`
+	Article    *ArticleOutput    `json:"article,omitempty"`
+	Collection *CollectionOutput `json:"collection,omitempty"`
+	Shop       *ShopOutput       `json:"shop,omitempty"`
+}
+
+// kind returns the kind of the record a line holds
+func (r *ndjsonRecord) kind() string {
+	switch {
+	case r.Product != nil:
+		return kindProduct
+	case r.Page != nil:
+		return kindPage
+	case r.Article != nil:
+		return kindArticle
+	case r.Collection != nil:
+		return "collection"
+	case r.Shop != nil:
+		return kindShop
+	}
+	return ""
+}
+
+// addTo puts the record of a line into a data file
+func (r *ndjsonRecord) addTo(output *Output) {
+	switch {
+	case r.Product != nil:
+		output.Products = append(output.Products, r.Product)
+	case r.Page != nil:
+		output.Pages = append(output.Pages, r.Page)
+	case r.Article != nil:
+		output.Articles = append(output.Articles, r.Article)
+	case r.Collection != nil:
+		output.Collections = append(output.Collections, r.Collection)
+	case r.Shop != nil:
+		output.Shop = r.Shop
+	}
+}
+
+// ndjsonRecords returns the records of a data file as NDJSON lines, without the header
+func ndjsonRecords(output *Output) []*ndjsonRecord {
+	var records []*ndjsonRecord
+	for _, p := range output.Products {
+		records = append(records, &ndjsonRecord{Product: p})
+	}
+	for _, p := range output.Pages {
+		records = append(records, &ndjsonRecord{Page: p})
+	}
+	for _, a := range output.Articles {
+		records = append(records, &ndjsonRecord{Article: a})
+	}
+	for _, c := range output.Collections {
+		records = append(records, &ndjsonRecord{Collection: c})
+	}
+	if output.Shop != nil {
+		records = append(records, &ndjsonRecord{Shop: output.Shop})
+	}
+	return records
+}
+
+// ndjsonWriter writes records to an NDJSON data file one line at a time, so that everything
+// written so far is kept if an export is interrupted
+type ndjsonWriter struct {
+	w io.Writer
+	// offset is the number of bytes written, so the end of the last complete line
+	offset int64
+}
+
+// newNDJSONWriter writes the header line and returns a writer for the records
+func newNDJSONWriter(w io.Writer, header *Header) (*ndjsonWriter, error) {
+	nw := &ndjsonWriter{w: w}
+	h := *header
+	h.FormatVersion = FormatVersion
+	return nw, nw.write(&ndjsonRecord{Header: &h})
+}
+
+func (nw *ndjsonWriter) write(record *ndjsonRecord) error {
+	// JSONMarshal ends the line with a newline
+	b, err := JSONMarshal(record)
+	if err != nil {
+		return err
+	}
+	n, err := nw.w.Write(b)
+	nw.offset += int64(n)
+	return err
+}
+
+func writeNDJSON(w io.Writer, output *Output) error {
+	bw := bufio.NewWriter(w)
+	nw, err := newNDJSONWriter(bw, output.Header)
+	if err != nil {
+		return err
+	}
+	for _, record := range ndjsonRecords(output) {
+		if err := nw.write(record); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func readNDJSON(r io.Reader) (*Output, error) {
+	output := &Output{}
+	err := streamNDJSON(r, func(record *ndjsonRecord) error {
+		if record.Header != nil {
+			output.Header = record.Header
+		}
+		record.addTo(output)
+		return nil
+	})
+	return output, err
+}
+
+// streamNDJSON reads an NDJSON data file line by line and hands the header and each
+// record to fn as soon as it is read
+func streamNDJSON(r io.Reader, fn func(*ndjsonRecord) error) error {
+	br := bufio.NewReader(r)
+	var header *Header
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			var record ndjsonRecord
+			if err := json.Unmarshal(b, &record); err != nil {
+				return fmt.Errorf("line %d: %s", line, err)
+			}
+			if err := checkNDJSONRecord(&record, header, line); err != nil {
+				return err
+			}
+			if record.Header != nil {
+				header = record.Header
+			}
+			if err := fn(&record); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if header == nil {
+		return errors.New("the file has no header line")
+	}
+	return nil
+}
+
+// eachRecord reads a data file and hands its header and then each record to fn, together
+// with the index of the record and the number of records of its kind. NDJSON files are
+// streamed rather than read at once, so the number of records is unknown and 0.
+// Reading stops at the first error returned by fn.
+func eachRecord(fileName string, format string, fn func(record *ndjsonRecord, i int, total int) error) error {
+	counts := map[string]int{}
+	if format == "ndjson" {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return streamNDJSON(f, func(record *ndjsonRecord) error {
+			kind := record.kind()
+			err := fn(record, counts[kind], 0)
+			counts[kind]++
+			return err
+		})
+	}
+
+	data, err := readOutputAs(fileName, format)
+	if err != nil {
+		return err
+	}
+	records := ndjsonRecords(data)
+	totals := map[string]int{}
+	for _, record := range records {
+		totals[record.kind()]++
+	}
+	header := data.Header
+	if header == nil {
+		header = &Header{}
+	}
+	if err := fn(&ndjsonRecord{Header: header}, 0, 1); err != nil {
+		return err
+	}
+	for _, record := range records {
+		kind := record.kind()
+		if err := fn(record, counts[kind], totals[kind]); err != nil {
+			return err
+		}
+		counts[kind]++
+	}
+	return nil
+}
+
+// checkNDJSONRecord returns an error if a line doesn't hold exactly one record,
+// or the header isn't the first line
+func checkNDJSONRecord(record *ndjsonRecord, header *Header, line int) error {
+	if record.Header != nil {
+		if line != 1 {
+			return fmt.Errorf("line %d: the header must be the first line", line)
+		}
+		if record.Header.FormatVersion != FormatVersion {
+			return fmt.Errorf("the file is not in format version %d, export it again", FormatVersion)
+		}
+		if record.kind() != "" {
+			return fmt.Errorf("line %d: the header line can't hold a record", line)
+		}
+		return nil
+	}
+	if header == nil {
+		return errors.New("the file has no header line")
+	}
+	n := 0
+	for _, set := range []bool{record.Product != nil, record.Page != nil, record.Article != nil, record.Collection != nil, record.Shop != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("line %d: a line must hold exactly one record", line)
+	}
+	return nil
+}
+
+// exportSink receives the records of an export. With --format ndjson each record is written
+// to the output file as soon as it arrives, with any other format the records are collected
+// and written once the export is complete. Streamed exports keep a checkpoint of their progress.
+type exportSink struct {
+	output     *Output
+	file       *os.File
+	fileName   string
+	stream     *ndjsonWriter
+	checkpoint *checkpoint
+}
+
+// newExportSink returns a sink that adds the records to output unless they are streamed.
+// The checkpoint of a streamed export holds command and params, and with --resume the
+// export continues the output file of the interrupted run.
+func newExportSink(output *Output, command string, params map[string]string) (*exportSink, error) {
+	sink := &exportSink{output: output}
+	fileName, format := exportFile()
+	if format != "ndjson" || viper.GetString("export.layout") == "dir" {
+		return sink, nil
+	}
+	sink.fileName = fileName
+
+	if viper.GetBool("export.resume") {
+		return sink, sink.resume(command, params)
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := newNDJSONWriter(f, output.Header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sink.file, sink.stream = f, stream
+	sink.checkpoint = newCheckpoint(checkpointFile(fileName), command, params)
+	sink.checkpoint.Offset = stream.offset
+	return sink, sink.checkpoint.save()
+}
+
+// resume opens the output file of an interrupted export for appending. Anything written
+// after the last checkpoint, like a partly written line, is cut off.
+func (s *exportSink) resume(command string, params map[string]string) error {
+	c, err := loadCheckpoint(checkpointFile(s.fileName), command, params)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.fileName, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(c.Offset); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(c.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.stream, s.checkpoint = f, &ndjsonWriter{w: f, offset: c.Offset}, c
+	fmt.Printf("== Resuming the export to %s (%s)\n", s.fileName, c.describe())
+	return nil
+}
+
+// pending returns the products that still need to be exported. When resuming, these
+// are the products after the last one of the checkpoint.
+func (s *exportSink) pending(products []*shopify.Product) ([]*shopify.Product, error) {
+	ids := make([]*int, len(products))
+	for i, p := range products {
+		ids[i] = p.Id
+	}
+	start, err := s.resumeAt(ids)
+	if err != nil {
+		return nil, err
+	}
+	return products[start:], nil
+}
+
+// resumeAt returns the index of the first product ID that still needs to be exported
+func (s *exportSink) resumeAt(ids []*int) (int, error) {
+	c := s.checkpoint
+	if c == nil || c.LastProductId == nil {
+		return 0, nil
+	}
+	for i, id := range ids {
+		if id != nil && *id == *c.LastProductId {
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("product %d of the checkpoint is no longer part of the export, start over without --resume", *c.LastProductId)
+}
+
+func (s *exportSink) addProduct(p *ProductOutput) error {
+	if s.stream == nil {
+		s.output.Products = append(s.output.Products, p)
+		return nil
+	}
+	if err := s.stream.write(&ndjsonRecord{Product: p}); err != nil {
+		return err
+	}
+	s.checkpoint.Offset = s.stream.offset
+	return s.checkpoint.productDone(p.Id)
+}
+
+func (s *exportSink) addCollection(c *CollectionOutput) error {
+	if s.stream == nil {
+		s.output.Collections = append(s.output.Collections, c)
+		return nil
+	}
+	return s.stream.write(&ndjsonRecord{Collection: c})
+}
+
+// close writes the collected records, or completes the streamed output file
+func (s *exportSink) close() error {
+	if s.stream == nil {
+		return writeExport(s.output)
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if err := s.checkpoint.remove(); err != nil {
+		return err
+	}
+	fmt.Println("== Exported to", s.fileName)
+	return nil
+}
+
+// abort closes a streamed output file, keeping the records written so far
+// and the checkpoint to resume from
+func (s *exportSink) abort() {
+	if s.file != nil {
+		s.file.Close()
+		fmt.Println("== Exported incompletely to", s.fileName)
+		fmt.Println("   Run the same command with --resume to continue")
+	}
+}
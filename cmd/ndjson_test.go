@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNDJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeNDJSON(&buf, testOutput()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if want := 1 + len(ndjsonRecords(testOutput())); len(lines) != want {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), want, buf.String())
+	}
+	if !strings.HasPrefix(lines[0], `{"header":`) {
+		t.Errorf("first line isn't the header: %s", lines[0])
+	}
+}
+
+func TestReadNDJSONErrors(t *testing.T) {
+	header := `{"header":{"format_version":3}}` + "\n"
+	tests := map[string]string{
+		"no header":     `{"product":{"id":1}}` + "\n",
+		"old version":   `{"header":{"format_version":2}}` + "\n",
+		"two records":   header + `{"product":{"id":1},"page":{"id":2}}` + "\n",
+		"no record":     header + `{}` + "\n",
+		"second header": header + header,
+		"broken line":   header + `{"product":` + "\n",
+	}
+	for name, data := range tests {
+		if _, err := readNDJSON(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestStreamNDJSONWithoutTrailingNewline(t *testing.T) {
+	data := `{"header":{"format_version":3}}` + "\n" + `{"product":{"id":1}}`
+	var kinds []string
+	err := streamNDJSON(strings.NewReader(data), func(record *ndjsonRecord) error {
+		kinds = append(kinds, record.kind())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(kinds, ",") != ","+kindProduct {
+		t.Errorf("got records %q", kinds)
+	}
+}
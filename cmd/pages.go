@@ -0,0 +1,76 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/caarlos0/spin"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// pagesCmd represents the "export pages" command
+var pagesCmd = &cobra.Command{
+	Use:   "pages",
+	Short: "export the power-editor content of all pages",
+
+	// Do all the error handling pre run
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check for required API credentials
+		return requiredFlagsError(checkGlobalRequiredFlags("export"))
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := GetStore("export")
+
+		s := spin.New("  \033[36m Scanning pages \033[m %s")
+		s.Set(spin.Spin1)
+		s.Start()
+
+		opt := &contentListOptions{Fields: []string{"id", "handle", "title", "body_html"}}
+		pages, err := store.ListContent(kindPage, 0, opt)
+		s.Stop()
+		if err != nil {
+			return err
+		}
+
+		output := newOutput(store, viper.GetString("export.namespace"))
+		for i, page := range pages {
+			progress := fmt.Sprintf("%d of %d", i, len(pages))
+			s = spin.New("  \033[36m Fetching page " + progress + "\033[m %s")
+			s.Set(spin.Spin1)
+			s.Start()
+			fields, err := exportContent(contentRef{kind: kindPage, id: *page.Id}, page, store)
+			s.Stop()
+			if err != nil {
+				return err
+			}
+			output.Pages = append(output.Pages, newPageOutput(page, fields))
+		}
+
+		return writeExport(&output)
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(pagesCmd)
+}
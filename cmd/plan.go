@@ -0,0 +1,185 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/viper"
+)
+
+// Actions a dry-run reports for a single field
+const (
+	actionCreate    = "create"
+	actionUpdate    = "update"
+	actionDelete    = "delete"
+	actionUnchanged = "unchanged"
+)
+
+// FieldChange describes what an import would do to one field of a record
+type FieldChange struct {
+	Field  string  `json:"field"`
+	Action string  `json:"action"`
+	Old    *string `json:"old,omitempty"`
+	New    *string `json:"new,omitempty"`
+
+	// metafield changes keep the metafields they were computed from so they can be applied
+	existing *shopify.Metafield
+	desired  *shopify.Metafield
+}
+
+// ImportPlan is the list of changes an import would apply to a product, page, article or collection
+type ImportPlan struct {
+	Kind    string         `json:"kind"`
+	Id      *int           `json:"id"`
+	Handle  *string        `json:"handle"`
+	Changes []*FieldChange `json:"changes"`
+}
+
+// PlanImport compares an exported record with the current state of the resource it would be
+// imported into and returns the changes an import would make. Nothing is written.
+func PlanImport(ref contentRef, record contentOutput, store Store) (*ImportPlan, error) {
+	existing, err := store.ListMetafields(ref, viper.GetString("import.namespace"))
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ImportPlan{Kind: ref.kind}
+	if ref.hasContent() {
+		current, err := store.GetContent(ref)
+		if err != nil {
+			return nil, err
+		}
+		plan.Id, plan.Handle = current.Id, current.Handle
+		if !viper.GetBool("import.metafields-only") {
+			if err := plan.addContentChanges(ref, current, record.content(), store); err != nil {
+				return nil, err
+			}
+		}
+	}
+	metafields := AssembleMetafieldData(record.outputFields())
+	plan.Changes = append(plan.Changes, diffMetafields(existing, metafields, viper.GetBool("import.prune"))...)
+
+	if p, ok := record.(*ProductOutput); ok {
+		variantChanges, err := planVariants(ref.id, p.Variants, store)
+		if err != nil {
+			return nil, err
+		}
+		plan.Changes = append(plan.Changes, variantChanges...)
+	}
+	return plan, nil
+}
+
+// addContentChanges records the changes to the title, body and SEO tags of a resource
+func (plan *ImportPlan) addContentChanges(ref contentRef, current *content, desired *content, store Store) error {
+	titleTag, descriptionTag, err := getSeoTags(ref, store)
+	if err != nil {
+		return err
+	}
+	plan.addValueChange("title", current.Title, desired.Title)
+	plan.addValueChange("body_html", current.BodyHtml, desired.BodyHtml)
+	plan.addValueChange("metafields_global_title_tag", titleTag, desired.MetafieldsGlobalTitleTag)
+	plan.addValueChange("metafields_global_description_tag", descriptionTag, desired.MetafieldsGlobalDescriptionTag)
+	return nil
+}
+
+// addValueChange records the change of a plain product property. Properties missing
+// from the data file are left untouched by an import and are therefore not reported.
+func (plan *ImportPlan) addValueChange(field string, old *string, new *string) {
+	if new == nil {
+		return
+	}
+	change := &FieldChange{Field: field, Old: old, New: new}
+	switch {
+	case old == nil:
+		change.Action = actionCreate
+	case *old != *new:
+		change.Action = actionUpdate
+	default:
+		change.Action = actionUnchanged
+	}
+	plan.Changes = append(plan.Changes, change)
+}
+
+// diffMetafields compares the current metafields of a product with the desired ones by key.
+// Metafields that only exist in the store are deleted if prune is set and kept otherwise.
+func diffMetafields(existing []*shopify.Metafield, desired []*shopify.Metafield, prune bool) (changes []*FieldChange) {
+	byKey := make(map[string]*shopify.Metafield)
+	for _, m := range existing {
+		byKey[*m.Key] = m
+	}
+
+	seen := make(map[string]bool)
+	for _, d := range desired {
+		change := &FieldChange{Field: "metafield " + *d.Key, New: d.Value, desired: d}
+		if m, ok := byKey[*d.Key]; !ok {
+			change.Action = actionCreate
+		} else {
+			change.Old = m.Value
+			change.existing = m
+			if m.Value == nil || *m.Value != *d.Value {
+				change.Action = actionUpdate
+			} else {
+				change.Action = actionUnchanged
+			}
+		}
+		seen[*d.Key] = true
+		changes = append(changes, change)
+	}
+
+	if !prune {
+		return
+	}
+	for _, m := range existing {
+		if !seen[*m.Key] {
+			changes = append(changes, &FieldChange{Field: "metafield " + *m.Key, Action: actionDelete, Old: m.Value, existing: m})
+		}
+	}
+	return
+}
+
+// Print writes a human readable version of the plan
+func (plan *ImportPlan) Print(w io.Writer) {
+	kind := strings.Title(strings.Replace(plan.Kind, "_", " ", -1))
+	if plan.Id != nil && plan.Handle != nil {
+		fmt.Fprintf(w, "== %s %s (id: %d)\n", kind, *plan.Handle, *plan.Id)
+	} else {
+		fmt.Fprintf(w, "== %s\n", kind)
+	}
+	for _, c := range plan.Changes {
+		fmt.Fprintf(w, "   %-10s %s\n", c.Action, c.Field)
+	}
+}
+
+// printPlanSummary writes the number of changes per action for a list of plans
+func printPlanSummary(w io.Writer, plans []*ImportPlan) {
+	counts := make(map[string]int)
+	for _, plan := range plans {
+		for _, c := range plan.Changes {
+			counts[c.Action]++
+		}
+	}
+	fmt.Fprintf(w, "== Dry run: %d records, %d create, %d update, %d delete, %d unchanged. Nothing was written.\n",
+		len(plans), counts[actionCreate], counts[actionUpdate], counts[actionDelete], counts[actionUnchanged])
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/dommmel/goshopping/shopify"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestDiffMetafields(t *testing.T) {
+	existing := []*shopify.Metafield{
+		{Id: intPtr(1), Key: strPtr("tabs"), Value: strPtr("a<!--|col|-->b")},
+		{Id: intPtr(2), Key: strPtr("video"), Value: strPtr("XGBQkxcM8DI")},
+		{Id: intPtr(3), Key: strPtr("single"), Value: strPtr("1st")},
+	}
+	desired := AssembleMetafieldData([]*OutputField{
+		{Key: strPtr("tabs"), Data: FieldData{{"a", "b"}}},
+		{Key: strPtr("single"), Data: FieldData{{"2nd"}}},
+		{Key: strPtr("products"), Data: FieldData{{"ball-1"}, {"blackroll-mat"}}},
+	})
+
+	for _, prune := range []bool{false, true} {
+		want := map[string]string{
+			"metafield tabs":     actionUnchanged,
+			"metafield single":   actionUpdate,
+			"metafield products": actionCreate,
+		}
+		if prune {
+			want["metafield video"] = actionDelete
+		}
+		changes := diffMetafields(existing, desired, prune)
+		if len(changes) != len(want) {
+			t.Fatalf("prune=%v: got %d changes, want %d", prune, len(changes), len(want))
+		}
+		for _, c := range changes {
+			if want[c.Field] != c.Action {
+				t.Errorf("prune=%v: %s: got action %q, want %q", prune, c.Field, c.Action, want[c.Field])
+			}
+			if c.Action == actionUpdate && *c.existing.Id != 3 {
+				t.Errorf("%s: update should target existing metafield 3, got %d", c.Field, *c.existing.Id)
+			}
+		}
+	}
+}
+
+func TestAddValueChange(t *testing.T) {
+	plan := &ImportPlan{}
+	plan.addValueChange("title", strPtr("Clown1"), strPtr("Clown1"))
+	plan.addValueChange("body_html", strPtr("<p>old</p>"), strPtr("<p>new</p>"))
+	plan.addValueChange("metafields_global_title_tag", nil, strPtr("Clowns"))
+	plan.addValueChange("metafields_global_description_tag", strPtr("untouched"), nil)
+
+	want := []string{actionUnchanged, actionUpdate, actionCreate}
+	if len(plan.Changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(plan.Changes), len(want))
+	}
+	for i, c := range plan.Changes {
+		if c.Action != want[i] {
+			t.Errorf("%s: got action %q, want %q", c.Field, c.Action, want[i])
+		}
+	}
+}
@@ -0,0 +1,479 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// poEntry is a message of a gettext PO file. The context of a message is the record key
+// followed by the name of the text ("title", ...), "fields/<key>/<row>/<col>" for the cells
+// of a field or "fields/<key>" for its shape.
+type poEntry struct {
+	comments []string
+	flags    []string
+	context  string
+	id       string
+	str      string
+}
+
+func writePOT(w io.Writer, output *Output) error {
+	return writePO(w, output, "")
+}
+
+func writePOFile(w io.Writer, output *Output) error {
+	return writePO(w, output, viper.GetString("export.target-language"))
+}
+
+// writePO writes the texts of a data file as messages to be translated. Metafield cells that hold
+// URLs, handles, IDs or numbers are included, so the translated file holds complete fields,
+// but they are marked for translators to leave alone. Empty cells are left out, as the empty
+// message is the header; fields with empty cells or none at all get a message with their shape
+// instead, so they keep their number of rows and columns.
+func writePO(w io.Writer, output *Output, language string) error {
+	bw := bufio.NewWriter(w)
+
+	header := []string{
+		"Project-Id-Version: power-editor",
+		"POT-Creation-Date: " + time.Now().Format("2006-01-02 15:04-0700"),
+		"Language: " + language,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: 8bit",
+		"X-Source-Language: " + viper.GetString("export.source-language"),
+	}
+	if h := output.Header; h != nil {
+		for _, name := range headerNames {
+			if value := headerValue(h, name); value != "" {
+				header = append(header, poHeaderField(name)+": "+value)
+			}
+		}
+	}
+	writePOEntry(bw, &poEntry{str: strings.Join(header, "\n") + "\n"})
+
+	for _, r := range flattenOutput(output) {
+		key := recordKey(r)
+		c := r.content
+		for i, text := range []*string{c.Title, c.BodyHtml, c.MetafieldsGlobalTitleTag, c.MetafieldsGlobalDescriptionTag} {
+			// Variant titles are made of their option values and can't be imported
+			if text != nil && *text != "" && r.kind != kindVariant {
+				writePOEntry(bw, &poEntry{context: key + "/" + contentColumns[i], id: *text})
+			}
+		}
+		for _, f := range r.fields {
+			fieldKey := key + "/fields/" + keyEscaper.Replace(stringValue(f.Key))
+			if hasEmptyCells(f) {
+				writePOEntry(bw, &poEntry{
+					comments: []string{"do not translate", "rows x columns of the field"},
+					context:  fieldKey,
+					id:       fmt.Sprintf("%dx%d", len(f.Data), fieldWidth([]*OutputField{f})),
+				})
+			}
+			for i, row := range f.Data {
+				for j, cell := range row {
+					if cell == "" {
+						continue
+					}
+					e := &poEntry{context: fmt.Sprintf("%s/%d/%d", fieldKey, i, j), id: cell}
+					if !translatable(cell) {
+						e.comments = append(e.comments, "do not translate")
+					}
+					writePOEntry(bw, e)
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// hasEmptyCells tells if the shape of a field can't be told from its non-empty cells
+func hasEmptyCells(f *OutputField) bool {
+	if fieldWidth([]*OutputField{f}) == 0 {
+		return true
+	}
+	for _, row := range f.Data {
+		for _, cell := range row {
+			if cell == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writePOEntry(w *bufio.Writer, e *poEntry) {
+	for _, c := range e.comments {
+		fmt.Fprintf(w, "#. %s\n", c)
+	}
+	if e.context != "" {
+		fmt.Fprintf(w, "msgctxt %s\n", poQuote(e.context))
+	}
+	fmt.Fprintf(w, "msgid %s\n", poQuote(e.id))
+	fmt.Fprintf(w, "msgstr %s\n\n", poQuote(e.str))
+}
+
+var poEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+
+// poQuote returns a PO string. Multi-line strings are split after each line break.
+func poQuote(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 1 {
+		return `"` + poEscaper.Replace(s) + `"`
+	}
+	quoted := []string{`""`}
+	for _, l := range lines {
+		quoted = append(quoted, `"`+poEscaper.Replace(l)+`"`)
+	}
+	return strings.Join(quoted, "\n")
+}
+
+// readPO reads a translated PO file (or its template). Messages take their translation,
+// or their source text if they haven't been translated or are marked fuzzy.
+func readPO(r io.Reader) (*Output, error) {
+	entries, err := parsePO(r)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &Header{}
+	var records []*flatRecord
+	byKey := make(map[string]*flatRecord)
+	cells := make(map[*flatRecord]map[string]map[int]map[int]string)
+	shapes := make(map[*flatRecord]map[string][2]int)
+	for _, e := range entries {
+		if e.context == "" {
+			if e.id == "" {
+				if err := readPOHeader(e.str, header); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		text := e.str
+		if text == "" || hasFlag(e.flags, "fuzzy") {
+			text = e.id
+		}
+
+		record, rest, err := parseRecordKey(e.context)
+		if err != nil {
+			return nil, err
+		}
+		if existing := byKey[recordKey(record)]; existing != nil {
+			record = existing
+		} else {
+			byKey[recordKey(record)] = record
+			records = append(records, record)
+			cells[record] = make(map[string]map[int]map[int]string)
+			shapes[record] = make(map[string][2]int)
+		}
+
+		c := record.content
+		texts := map[string]**string{
+			"title":                             &c.Title,
+			"body_html":                         &c.BodyHtml,
+			"metafields_global_title_tag":       &c.MetafieldsGlobalTitleTag,
+			"metafields_global_description_tag": &c.MetafieldsGlobalDescriptionTag,
+		}
+		switch {
+		case len(rest) == 1 && texts[rest[0]] != nil:
+			*texts[rest[0]] = &text
+		case len(rest) == 2 && rest[0] == "fields":
+			key := rest[1]
+			if cells[record][key] == nil {
+				cells[record][key] = make(map[int]map[int]string)
+				record.fields = append(record.fields, &OutputField{Key: &key})
+			}
+			// Older versions wrote fields without cells with an empty message
+			if e.id != "" {
+				var shape [2]int
+				if _, err := fmt.Sscanf(e.id, "%dx%d", &shape[0], &shape[1]); err != nil {
+					return nil, fmt.Errorf("%s: invalid field shape '%s'", e.context, e.id)
+				}
+				shapes[record][key] = shape
+			}
+		case len(rest) == 4 && rest[0] == "fields":
+			i, err := strconv.Atoi(rest[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid row number", e.context)
+			}
+			j, err := strconv.Atoi(rest[3])
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid column number", e.context)
+			}
+			key := rest[1]
+			if cells[record][key] == nil {
+				cells[record][key] = make(map[int]map[int]string)
+				record.fields = append(record.fields, &OutputField{Key: &key})
+			}
+			if cells[record][key][i] == nil {
+				cells[record][key][i] = make(map[int]string)
+			}
+			cells[record][key][i][j] = text
+		default:
+			return nil, fmt.Errorf("invalid message context '%s'", e.context)
+		}
+	}
+
+	for _, r := range records {
+		for _, f := range r.fields {
+			f.Data = fieldDataFromCells(fillCells(cells[r][*f.Key], shapes[r][*f.Key]))
+		}
+	}
+	return unflattenOutput(header, records)
+}
+
+// poHeaderField returns the name of the PO header field holding a header value, e.g. "X-Store"
+func poHeaderField(name string) string {
+	words := strings.Split(name, "_")
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return "X-" + strings.Join(words, "-")
+}
+
+// readPOHeader sets the header values found in the header entry of a PO file
+func readPOHeader(text string, header *Header) error {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, ":"); i >= 0 {
+			fields[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	for _, name := range headerNames {
+		if value := fields[poHeaderField(name)]; value != "" {
+			if err := setHeaderValue(header, name, value); err != nil {
+				return fmt.Errorf("PO header: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseRecordKey returns the record a key starts with and the rest of the key
+func parseRecordKey(key string) (*flatRecord, []string, error) {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		var err error
+		if parts[i], err = url.PathUnescape(parts[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	r := &flatRecord{kind: parts[0], content: &content{}}
+	n := 1
+	switch r.kind {
+	case kindShop:
+		n = 0
+	case kindArticle, kindVariant:
+		n = 2
+	}
+	if len(parts) < 1+n {
+		return nil, nil, fmt.Errorf("invalid key '%s'", key)
+	}
+	if n == 2 {
+		r.parent = &content{}
+		if err := parseContentKey(parts[1], r.parent); err != nil {
+			return nil, nil, err
+		}
+	}
+	if n > 0 {
+		id := parts[n]
+		switch {
+		case r.kind == kindVariant && strings.HasPrefix(id, "sku:"):
+			sku := strings.TrimPrefix(id, "sku:")
+			r.sku = &sku
+		case r.kind == kindVariant && strings.HasPrefix(id, "options:"):
+			r.options = strings.Split(strings.TrimPrefix(id, "options:"), optionSeparator)
+		default:
+			if err := parseContentKey(id, r.content); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return r, parts[1+n:], nil
+}
+
+// parseContentKey sets the handle or id of c
+func parseContentKey(key string, c *content) error {
+	if !strings.HasPrefix(key, "id:") {
+		c.Handle = &key
+		return nil
+	}
+	var err error
+	c.Id, err = parseIntPtr(strings.TrimPrefix(key, "id:"))
+	return err
+}
+
+// fillCells adds the empty cells left out of a PO file. The field has at least the rows and
+// columns of its shape, and as the rows of a field have the same number of columns, every row
+// gets as many as the widest one.
+func fillCells(cells map[int]map[int]string, shape [2]int) map[int]map[int]string {
+	rows, cols := shape[0], shape[1]
+	for i, row := range cells {
+		if i+1 > rows {
+			rows = i + 1
+		}
+		for j := range row {
+			if j+1 > cols {
+				cols = j + 1
+			}
+		}
+	}
+	for i := 0; i < rows; i++ {
+		if cells[i] == nil {
+			cells[i] = make(map[int]string)
+		}
+		for j := 0; j < cols; j++ {
+			if _, ok := cells[i][j]; !ok {
+				cells[i][j] = ""
+			}
+		}
+	}
+	return cells
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePO returns the messages of a PO file. Obsolete messages and plural forms are skipped.
+func parsePO(r io.Reader) ([]*poEntry, error) {
+	var entries []*poEntry
+	e := &poEntry{}
+	var target *string
+	seen := false
+
+	flush := func() {
+		if seen {
+			entries = append(entries, e)
+		}
+		e, target, seen = &poEntry{}, nil, false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		l := strings.TrimSpace(scanner.Text())
+		switch {
+		case l == "":
+			flush()
+		case strings.HasPrefix(l, "#~"):
+			target = nil
+		case strings.HasPrefix(l, "#,"):
+			if seen {
+				flush()
+			}
+			for _, f := range strings.Split(l[2:], ",") {
+				e.flags = append(e.flags, strings.TrimSpace(f))
+			}
+		case strings.HasPrefix(l, "#"):
+			if seen {
+				flush()
+			}
+		case strings.HasPrefix(l, `"`):
+			if target == nil {
+				continue
+			}
+			s, err := poUnquote(l)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			*target += s
+		default:
+			keyword, value := l, ""
+			if i := strings.IndexAny(l, " \t"); i >= 0 {
+				keyword, value = l[:i], strings.TrimSpace(l[i:])
+			}
+			if keyword == "msgctxt" && seen {
+				flush()
+			}
+			switch keyword {
+			case "msgctxt":
+				target = &e.context
+			case "msgid":
+				if seen && e.id != "" {
+					flush()
+				}
+				target = &e.id
+			case "msgstr", "msgstr[0]":
+				target = &e.str
+			default:
+				// msgid_plural and further plural forms
+				target = nil
+				continue
+			}
+			seen = true
+			s, err := poUnquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			*target = s
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return entries, nil
+}
+
+// poUnquote returns the value of a quoted PO string
+func poUnquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	var b strings.Builder
+	s = s[1 : len(s)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPOKeepsEmptyCells(t *testing.T) {
+	output := testOutput()
+	output.Pages[0].Fields = []*OutputField{
+		{Key: strPtr("tabs"), Data: FieldData{{"Intro", "<p>Hello</p>"}, {"", ""}}},
+		{Key: strPtr("video"), Data: FieldData{{"", ""}, {"", ""}}},
+		{Key: strPtr("gallery"), Data: FieldData{}},
+	}
+	var buf bytes.Buffer
+	if err := writePOT(&buf, output); err != nil {
+		t.Fatal(err)
+	}
+	// The empty message is reserved for the header
+	if n := strings.Count(buf.String(), "msgid \"\"\nmsgstr"); n != 1 {
+		t.Errorf("got %d empty messages, want only the header", n)
+	}
+	got, err := readPO(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutIds(output)
+	assertSameOutput(t, got, output)
+}
+
+func TestReadPOTranslations(t *testing.T) {
+	po := `msgid ""
+msgstr ""
+"Language: en\n"
+
+#. do not translate
+msgctxt "product/blackroll-med-45/fields/testimonial/0/1"
+msgid "https://cdn.shopify.com/s/files/1/0429/1421/t/11/assets/Robert_Schleip.jpeg"
+msgstr ""
+
+msgctxt "product/blackroll-med-45/fields/testimonial/0/0"
+msgid ""
+"<p>Die Anwendungsmöglichkeiten</p>\n"
+"<p>der BLACKROLL®</p>"
+msgstr ""
+"<p>The applications</p>\n"
+"<p>of the BLACKROLL®</p>"
+
+#, fuzzy
+msgctxt "product/blackroll-med-45/title"
+msgid "BLACKROLL® MED 45"
+msgstr "BLACKROLL® MED 45 (EN)"
+
+msgctxt "variant/blackroll-med-45/options:45 cm %2F green/fields/size/0/0"
+msgid "45 cm"
+msgstr "18 in"
+`
+	got, err := readPO(strings.NewReader(po))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := got.Products[0]
+	if *p.Handle != "blackroll-med-45" || *p.Title != "BLACKROLL® MED 45" {
+		t.Errorf("got product %s with title %q", *p.Handle, *p.Title)
+	}
+	want := "<p>The applications</p>\n<p>of the BLACKROLL®</p><!--|col|-->https://cdn.shopify.com/s/files/1/0429/1421/t/11/assets/Robert_Schleip.jpeg"
+	if got := metafieldValue(p.Fields[0]); got != want {
+		t.Errorf("got testimonial %q, want %q", got, want)
+	}
+	v := p.Variants[0]
+	if strings.Join(v.Options, "|") != "45 cm|green" || metafieldValue(v.Fields[0]) != "18 in" {
+		t.Errorf("got variant %v with size %q", v.Options, metafieldValue(v.Fields[0]))
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/spin"
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var productFilter ProductFilter
+
+// productsCmd represents the "export products" command
+var productsCmd = &cobra.Command{
+	Use:   "products",
+	Short: "export the power-editor content of all products matching the given filters",
+
+	// Do all the error handling pre run
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		bindFlags(cmd, "export", "include-product-info", "include-variants", "concurrency", "resume")
+
+		// Check for required API credentials
+		errorMsg := checkGlobalRequiredFlags("export")
+		errorMsg = append(errorMsg, checkExportResume()...)
+
+		switch productFilter.PublishedStatus {
+		case "published", "unpublished", "any":
+		default:
+			errorMsg = append(errorMsg, "published status '"+productFilter.PublishedStatus+"' is not valid")
+		}
+		if updatedAtMin, _ := cmd.Flags().GetString("updated-at-min"); updatedAtMin != "" {
+			t, err := parseTime(updatedAtMin)
+			if err != nil {
+				errorMsg = append(errorMsg, fmt.Sprintf("'%s' is not a valid date", updatedAtMin))
+			}
+			productFilter.UpdatedAtMin = t
+		}
+		return requiredFlagsError(errorMsg)
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := GetStore("export")
+
+		output := newOutput(store, viper.GetString("export.namespace"))
+		sink, err := newExportSink(&output, "export products", exportParams(store, productFilter.params()))
+		if err != nil {
+			return err
+		}
+
+		if bulk, ok := store.(bulkExporter); ok {
+			fmt.Println("== Exporting products")
+			err = bulk.ExportProducts(&productFilter, 0, sink.resumeAt, sink.addProduct)
+		} else {
+			err = exportFilteredProducts(&productFilter, store, sink)
+		}
+		if err != nil {
+			sink.abort()
+			return err
+		}
+		return sink.close()
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(productsCmd)
+	productsCmd.Flags().BoolP("include-product-info", "i", false, "Include product content (titles, descriptions) in export")
+	productsCmd.Flags().BoolP("include-variants", "V", false, "Include the power-editor metafields of variants in export")
+	productsCmd.Flags().Int("concurrency", 4, "the number of products fetched at once")
+	productsCmd.Flags().Bool("resume", false, "continue an interrupted export from its checkpoint (needs --format ndjson)")
+	productsCmd.Flags().StringVar(&productFilter.Vendor, "vendor", "", "only export products of this vendor")
+	productsCmd.Flags().StringVar(&productFilter.ProductType, "product-type", "", "only export products of this type")
+	productsCmd.Flags().StringVar(&productFilter.Tag, "tag", "", "only export products with this tag")
+	productsCmd.Flags().StringVar(&productFilter.PublishedStatus, "published-status", "any", `Possible values are "published", "unpublished" and "any"`)
+	productsCmd.Flags().String("updated-at-min", "", "only export products updated after this date (2006-01-02 or RFC 3339)")
+	productsCmd.Flags().StringSliceVar(&productFilter.Handles, "handles", nil, "only export the products with these handles (comma separated)")
+	productsCmd.Flags().IntSliceVar(&productFilter.Ids, "ids", nil, "only export the products with these IDs (comma separated)")
+}
+
+// exportFilteredProducts exports the products matching the filter one after the other
+func exportFilteredProducts(filter *ProductFilter, store Store, sink *exportSink) error {
+	s := spin.New("  \033[36m Scanning products \033[m %s")
+	s.Set(spin.Spin1)
+	s.Start()
+	products, err := GetProducts(filter, store)
+	s.Stop()
+	if err != nil {
+		return err
+	}
+
+	if products, err = sink.pending(products); err != nil {
+		return err
+	}
+	return exportProducts(products, store, sink.addProduct)
+}
+
+// ProductFilter selects the products of a store that are exported
+type ProductFilter struct {
+	Vendor          string
+	ProductType     string
+	Tag             string
+	PublishedStatus string
+	UpdatedAtMin    time.Time
+	Handles         []string
+	Ids             []int
+}
+
+// params returns the filters as options of a checkpoint
+func (f *ProductFilter) params() map[string]string {
+	var ids []string
+	for _, id := range f.Ids {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	var updatedAtMin string
+	if !f.UpdatedAtMin.IsZero() {
+		updatedAtMin = f.UpdatedAtMin.Format(time.RFC3339)
+	}
+	return map[string]string{
+		"vendor":           f.Vendor,
+		"product-type":     f.ProductType,
+		"tag":              f.Tag,
+		"published-status": f.PublishedStatus,
+		"updated-at-min":   updatedAtMin,
+		"handles":          strings.Join(f.Handles, ","),
+		"ids":              strings.Join(ids, ","),
+	}
+}
+
+// matches checks the filters the product list endpoint doesn't support
+func (f *ProductFilter) matches(p *shopify.Product) bool {
+	switch f.PublishedStatus {
+	case "published":
+		if p.PublishedAt == nil {
+			return false
+		}
+	case "unpublished":
+		if p.PublishedAt != nil {
+			return false
+		}
+	}
+
+	if f.Tag == "" {
+		return true
+	}
+	if p.Tags != nil {
+		for _, tag := range strings.Split(*p.Tags, ",") {
+			if strings.EqualFold(strings.TrimSpace(tag), f.Tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetProducts returns all products of the store that match the filter
+func GetProducts(filter *ProductFilter, store Store) ([]*shopify.Product, error) {
+	fmt.Println("== Exporting products")
+
+	productFields := exportProductFields()
+	if filter.Tag != "" {
+		productFields = append(productFields, "tags")
+	}
+	if filter.PublishedStatus != "" && filter.PublishedStatus != "any" {
+		productFields = append(productFields, "published_at")
+	}
+
+	opt := &shopify.ProductListOptions{
+		Fields:       productFields,
+		Vendor:       filter.Vendor,
+		ProductType:  filter.ProductType,
+		UpdatedAtMin: filter.UpdatedAtMin,
+		Handle:       strings.Join(filter.Handles, ","),
+		Ids:          filter.Ids,
+	}
+
+	products, err := store.ListProducts(opt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Products.List() returned error: %v\n", err)
+		return nil, err
+	}
+
+	var filtered []*shopify.Product
+	for _, p := range products {
+		if filter.matches(p) {
+			filtered = append(filtered, p)
+		}
+	}
+	if len(filtered) == 0 {
+		fmt.Fprintf(os.Stderr, "Products.List() returned no events\n")
+	}
+	return filtered, nil
+}
+
+// parseTime accepts a plain date as well as a full RFC 3339 timestamp
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
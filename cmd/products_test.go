@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dommmel/goshopping/shopify"
+)
+
+func TestProductFilterMatches(t *testing.T) {
+	now := time.Now()
+	published := &shopify.Product{PublishedAt: &now, Tags: strPtr("Sale, Fascia,Summer")}
+	unpublished := &shopify.Product{Tags: strPtr("")}
+
+	tests := []struct {
+		filter  ProductFilter
+		product *shopify.Product
+		want    bool
+	}{
+		{ProductFilter{PublishedStatus: "any"}, unpublished, true},
+		{ProductFilter{PublishedStatus: "published"}, published, true},
+		{ProductFilter{PublishedStatus: "published"}, unpublished, false},
+		{ProductFilter{PublishedStatus: "unpublished"}, published, false},
+		{ProductFilter{Tag: "fascia"}, published, true},
+		{ProductFilter{Tag: "summer"}, published, true},
+		{ProductFilter{Tag: "winter"}, published, false},
+		{ProductFilter{Tag: "sale"}, unpublished, false},
+	}
+	for _, test := range tests {
+		if got := test.filter.matches(test.product); got != test.want {
+			t.Errorf("%+v: got %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// callLimitHeader reports how full the leaky bucket of a store's API calls is, e.g. "32/40"
+const callLimitHeader = "X-Shopify-Shop-Api-Call-Limit"
+
+// callLimitReserve is the number of calls left free in the bucket, for other apps using the store
+const callLimitReserve = 2
+
+// callLimitTransport keeps the API calls of concurrent requests within Shopify's leaky bucket.
+// It estimates how full the bucket is from the call limit reported with each response and
+// the rate at which it leaks, and holds requests back while it is nearly full.
+type callLimitTransport struct {
+	base http.RoundTripper
+
+	mu sync.Mutex
+	// level is the number of calls in the bucket at the time of updated
+	level   float64
+	max     int
+	updated time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newCallLimitTransport(base http.RoundTripper) *callLimitTransport {
+	return &callLimitTransport{base: base, max: 40, now: time.Now, sleep: time.Sleep}
+}
+
+func (t *callLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.wait()
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.update(resp.Header.Get(callLimitHeader))
+	}
+	return resp, err
+}
+
+// leakRate returns the calls per second that leak out of the bucket. Stores with a larger
+// bucket leak faster, it takes 20 seconds to empty a full bucket.
+func (t *callLimitTransport) leakRate() float64 {
+	return float64(t.max) / 20
+}
+
+// levelAt returns the estimated number of calls in the bucket at the given time
+func (t *callLimitTransport) levelAt(now time.Time) float64 {
+	level := t.level - now.Sub(t.updated).Seconds()*t.leakRate()
+	if level < 0 {
+		return 0
+	}
+	return level
+}
+
+// wait blocks until there is room in the bucket for another call and takes it
+func (t *callLimitTransport) wait() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for {
+		now := t.now()
+		level := t.levelAt(now)
+		limit := float64(t.max - callLimitReserve)
+		if level+1 <= limit {
+			t.level, t.updated = level+1, now
+			return
+		}
+		delay := time.Duration((level + 1 - limit) / t.leakRate() * float64(time.Second))
+		t.mu.Unlock()
+		t.sleep(delay)
+		t.mu.Lock()
+	}
+}
+
+// update takes the level of the bucket from the call limit header of a response
+func (t *callLimitTransport) update(header string) {
+	parts := strings.Split(header, "/")
+	if len(parts) != 2 {
+		return
+	}
+	used, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	max, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || max <= callLimitReserve {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	// Calls sent after this one are in the bucket, too, but not yet counted by the store
+	if level := t.levelAt(now); float64(used) > level {
+		t.level = float64(used)
+	} else {
+		t.level = level
+	}
+	t.max, t.updated = max, now
+}
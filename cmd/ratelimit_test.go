@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallLimitTransportWaitsForRoom(t *testing.T) {
+	now := time.Date(2017, 10, 24, 15, 30, 0, 0, time.UTC)
+	var slept time.Duration
+	tr := newCallLimitTransport(nil)
+	tr.now = func() time.Time { return now }
+	tr.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	tr.update("38/40")
+	tr.wait()
+	// 38 calls of a 40 call bucket leave no room, it takes half a second to leak one at 2 calls/s
+	if slept != time.Second/2 {
+		t.Errorf("waited %s, want 500ms", slept)
+	}
+
+	slept = 0
+	now = now.Add(time.Minute)
+	tr.wait()
+	if slept != 0 {
+		t.Errorf("waited %s with an empty bucket", slept)
+	}
+}
+
+func TestCallLimitTransportReadsHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(callLimitHeader, "20/80")
+	}))
+	defer server.Close()
+
+	tr := newCallLimitTransport(http.DefaultTransport)
+	client := &http.Client{Transport: tr}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if tr.max != 80 || tr.level < 19 || tr.level > 20 {
+		t.Errorf("got level %.1f of %d, want 20 of 80", tr.level, tr.max)
+	}
+	if tr.leakRate() != 4 {
+		t.Errorf("got leak rate %.1f, want 4", tr.leakRate())
+	}
+}
+
+func TestFetchInOrder(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	fetch := func(i int) (*ProductOutput, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		id := i
+		return &ProductOutput{Id: &id}, nil
+	}
+
+	var got []int
+	err := fetchInOrder(50, 4, fetch, func(i int, pout *ProductOutput) error {
+		if *pout.Id != i {
+			t.Errorf("got product %d at %d", *pout.Id, i)
+		}
+		got = append(got, i)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 50 {
+		t.Errorf("got %d products, want 50", len(got))
+	}
+	if maxRunning > 4 {
+		t.Errorf("%d products were fetched at once, want at most 4", maxRunning)
+	}
+}
+
+func TestFetchInOrderStopsAtError(t *testing.T) {
+	fetch := func(i int) (*ProductOutput, error) {
+		if i == 3 {
+			return nil, errors.New("502 Bad Gateway")
+		}
+		return &ProductOutput{}, nil
+	}
+	emitted := 0
+	err := fetchInOrder(10, 2, fetch, func(i int, pout *ProductOutput) error {
+		emitted++
+		return nil
+	})
+	if err == nil || emitted != 3 {
+		t.Errorf("got %d products and error %v, want 3 and an error", emitted, err)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/spin"
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var backupFileName string
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore <backup>",
+	Short: "Restore products, pages, articles, collections and shop metafields from a backup written by import",
+
+	// Do all the error handling pre run
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+
+		// Check for required API credentials
+		errorMsg := checkGlobalRequiredFlags("import")
+
+		// Check for required backup file
+		if len(args) < 1 {
+			errorMsg = append(errorMsg, "path to backup file required as an argument")
+		} else {
+			backupFileName = args[0]
+			if _, err := os.Stat(backupFileName); os.IsNotExist(err) {
+				errorMsg = append(errorMsg, fmt.Sprintf("Can't access file. %v", err))
+			}
+		}
+
+		return requiredFlagsError(errorMsg)
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := GetStore("import")
+		data, err := readOutput(backupFileName)
+		if err != nil {
+			return err
+		}
+
+		for i, p := range data.Products {
+			restoreRecord(contentRef{kind: kindProduct}, i, len(data.Products), p, store)
+		}
+		for i, p := range data.Pages {
+			restoreRecord(contentRef{kind: kindPage}, i, len(data.Pages), p, store)
+		}
+		for i, a := range data.Articles {
+			ref := contentRef{kind: kindArticle}
+			if a.BlogId != nil {
+				ref.parentId = *a.BlogId
+			}
+			restoreRecord(ref, i, len(data.Articles), a, store)
+		}
+		for i, c := range data.Collections {
+			restoreRecord(contentRef{kind: c.kind()}, i, len(data.Collections), c, store)
+		}
+		if data.Shop != nil {
+			restoreRecord(contentRef{kind: kindShop}, 0, 1, data.Shop, store)
+		}
+		fmt.Println("== Restored from", backupFileName)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(restoreCmd)
+}
+
+// restoreRecord restores a single record of a backup. Backups are always taken from the
+// store they are restored to, so ref only needs the ID of the record to be filled in.
+func restoreRecord(ref contentRef, i int, total int, record contentOutput, store Store) {
+	progress := fmt.Sprintf("%d of %d", i, total)
+	s := spin.New("  \033[36m Restoring " + ref.kind + " " + progress + "\033[m %s")
+	s.Set(spin.Spin1)
+	s.Start()
+	defer s.Stop()
+
+	if ref.hasContent() {
+		c := record.content()
+		if c.Id == nil {
+			fmt.Fprintf(os.Stderr, "Can't restore %s without id\n", ref.kind)
+			return
+		}
+		ref.id = *c.Id
+	}
+	if err := restoreContent(ref, record, store); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't restore %s: %s\n", ref, err)
+	}
+}
+
+// backup collects the state of resources before they are changed by an import. Each resource
+// is appended to a journal in NDJSON as soon as it is saved, so the backup is complete up to
+// the last resource even if the import is interrupted. The finished import writes the backup
+// file in one piece and removes the journal.
+type backup struct {
+	fileName string
+	output   Output
+	journal  *os.File
+	stream   *ndjsonWriter
+}
+
+// newBackup returns a backup of the store that will be written to a timestamped file in dir
+func newBackup(dir string, store Store) *backup {
+	name := fmt.Sprintf("backup-%s.json", time.Now().Format("20060102-150405"))
+	return &backup{fileName: filepath.Join(dir, name), output: newOutput(store, viper.GetString("import.namespace"))}
+}
+
+// journalName returns the name of the journal of the backup, e.g. backup-20171024-153012.ndjson
+func (b *backup) journalName() string {
+	return strings.TrimSuffix(b.fileName, filepath.Ext(b.fileName)) + ".ndjson"
+}
+
+// add takes a snapshot of the resource ref points to, before record is imported into it,
+// and appends it to the journal
+func (b *backup) add(ref contentRef, record contentOutput, store Store) error {
+	c, fields, err := snapshotContent(ref, store)
+	if err != nil {
+		return err
+	}
+	var r ndjsonRecord
+	switch ref.kind {
+	case kindProduct:
+		p := newProductOutput(c, fields)
+		// Only save the variants if the import is going to touch them
+		if r, ok := record.(*ProductOutput); ok && len(r.Variants) > 0 {
+			variants, err := getProductVariants(ref.id, store)
+			if err != nil {
+				return err
+			}
+			p.Variants, err = exportVariants(ref.id, variants, viper.GetString("import.namespace"), true, store)
+			if err != nil {
+				return err
+			}
+		}
+		r.Product = p
+	case kindPage:
+		r.Page = newPageOutput(c, fields)
+	case kindArticle:
+		blog := &content{Id: &ref.parentId}
+		r.Article = newArticleOutput(blog, c, fields)
+	case kindCustomCollection, kindSmartCollection:
+		r.Collection = newCollectionOutput(ref.kind, c, fields)
+	case kindShop:
+		r.Shop = &ShopOutput{Fields: fields}
+	}
+	r.addTo(&b.output)
+
+	if b.journal == nil {
+		f, err := os.Create(b.journalName())
+		if err != nil {
+			return err
+		}
+		stream, err := newNDJSONWriter(f, b.output.Header)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		b.journal, b.stream = f, stream
+	}
+	return b.stream.write(&r)
+}
+
+// finish writes the backup file and removes the journal. The file is written under another
+// name first, so it is never left half written.
+func (b *backup) finish() error {
+	if b.journal == nil {
+		return nil
+	}
+	tmp := b.fileName + ".tmp"
+	if err := writeOutputAs(&b.output, tmp, "json"); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.fileName); err != nil {
+		return err
+	}
+	b.journal.Close()
+	return os.Remove(b.journalName())
+}
+
+// snapshotContent returns the current power-editor content of a resource
+func snapshotContent(ref contentRef, store Store) (*content, []*OutputField, error) {
+	metafields, err := store.ListMetafields(ref, viper.GetString("import.namespace"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ref.hasContent() {
+		return &content{}, GenerateProductDataOutput(metafields), nil
+	}
+
+	c, err := store.GetContent(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.MetafieldsGlobalTitleTag, c.MetafieldsGlobalDescriptionTag, err = getSeoTags(ref, store)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, GenerateProductDataOutput(metafields), nil
+}
+
+// restoreContent puts a resource back into the state recorded in a backup. Unlike an import
+// this also removes metafields and SEO tags that did not exist when the backup was taken.
+func restoreContent(ref contentRef, record contentOutput, store Store) error {
+	if ref.hasContent() {
+		if err := restoreSeoContent(ref, record.content(), store); err != nil {
+			return err
+		}
+	}
+
+	metafields := AssembleMetafieldData(record.outputFields())
+	if err := ReconcileMetafields(ref, metafields, true, store); err != nil {
+		return err
+	}
+	if p, ok := record.(*ProductOutput); ok {
+		return importVariants(ref.id, p.Variants, true, store)
+	}
+	return nil
+}
+
+// restoreSeoContent restores the title, body and SEO tags of a resource
+func restoreSeoContent(ref contentRef, c *content, store Store) error {
+	if err := store.EditContent(ref, c); err != nil {
+		return err
+	}
+
+	globalMetafields, err := store.ListMetafields(ref, "global")
+	if err != nil {
+		return err
+	}
+	var missing []*shopify.Metafield
+	for _, m := range globalMetafields {
+		missingTitleTag := *m.Key == "title_tag" && c.MetafieldsGlobalTitleTag == nil
+		missingDescriptionTag := *m.Key == "description_tag" && c.MetafieldsGlobalDescriptionTag == nil
+		if missingTitleTag || missingDescriptionTag {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return store.DeleteMetafields(ref, missing)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/viper"
+)
+
+func TestBackupJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "powereditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("import.namespace", "power-editor")
+	defer viper.Set("import.namespace", nil)
+
+	store := newMemoryStore("test.myshopify.com")
+	b := newBackup(dir, store)
+	for _, handle := range []string{"ball", "mat"} {
+		p := store.addProduct(&shopify.Product{Handle: strPtr(handle), Title: strPtr(handle)})
+		ref := contentRef{kind: kindProduct, id: *p.Id}
+		store.addMetafield(ref, "power-editor", "tabs", handle)
+		if err := b.add(ref, &ProductOutput{}, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Until the import is done, the backup is in the journal
+	if _, err := os.Stat(b.fileName); !os.IsNotExist(err) {
+		t.Errorf("the backup file was written before the import was done")
+	}
+	journal, err := readOutput(b.journalName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal.Products) != 2 || *journal.Products[1].Handle != "mat" {
+		t.Fatalf("got %d products in the journal", len(journal.Products))
+	}
+
+	if err := b.finish(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readOutput(b.fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameOutput(t, got, journal)
+	if _, err := os.Stat(b.journalName()); !os.IsNotExist(err) {
+		t.Error("the journal wasn't removed")
+	}
+}
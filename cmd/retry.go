@@ -0,0 +1,199 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// firstRetryDelay is the delay before the first retry, it doubles with every further retry
+	firstRetryDelay = time.Second
+	maxRetryDelay   = 32 * time.Second
+	// attemptTimeout is how long an attempt of a request may take, including reading the response body
+	attemptTimeout = 20 * time.Second
+)
+
+// retryTransport retries requests that failed with 429 (too many requests), a 5xx status or
+// a transport error like a reset connection or an attempt that ran out of time, with an
+// exponentially growing delay or the delay the store asks for in Retry-After. Requests that
+// aren't idempotent are only retried if the store can't have acted on them: after a 429 or
+// when the connection couldn't be made. Every retry and every request that is given up on
+// is logged. Each attempt has a deadline, so a store that stops sending a response body
+// doesn't stall a run.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	log     io.Writer
+	sleep   func(time.Duration)
+	timeout time.Duration
+}
+
+func newRetryTransport(base http.RoundTripper, retries int, log io.Writer) *retryTransport {
+	return &retryTransport{base: base, retries: retries, log: log, sleep: time.Sleep, timeout: attemptTimeout}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			copied := *req
+			copied.Body = body
+			r = &copied
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+		resp, err := t.base.RoundTrip(r.WithContext(ctx))
+		var reason string
+		if err != nil {
+			cancel()
+			// A request the caller cancelled isn't retried, but one that ran out of time is
+			if req.Context().Err() != nil || !retryError(req, err) {
+				return nil, err
+			}
+			reason = err.Error()
+		} else {
+			// The deadline covers reading the body, so the attempt ends when the body is closed
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			if !retryStatus(req, resp.StatusCode) {
+				return resp, nil
+			}
+			reason = resp.Status
+		}
+		// The URL holds the API credentials, so only the path is logged
+		request := req.Method + " " + req.URL.Path
+		if attempt >= t.retries {
+			fmt.Fprintf(t.log, "Giving up on %s after %d attempts: %s\n", request, attempt+1, reason)
+			return resp, err
+		}
+
+		retryAfter := ""
+		if resp != nil {
+			retryAfter = resp.Header.Get("Retry-After")
+			io.CopyN(ioutil.Discard, resp.Body, 512)
+			resp.Body.Close()
+		}
+		delay := retryDelay(retryAfter, attempt)
+		fmt.Fprintf(t.log, "Retrying %s in %s after %s (retry %d of %d)\n", request, delay, reason, attempt+1, t.retries)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		default:
+		}
+		t.sleep(delay)
+	}
+}
+
+// cancelOnClose is a response body that releases the context of its request once it is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryStatus tells if a request that failed with the given status is worth retrying.
+// A store that answers 429 hasn't acted on the request, so any request can be sent again.
+func retryStatus(req *http.Request, status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500 && idempotent(req)
+}
+
+// retryError tells if a request that failed with a transport error is worth retrying. A request
+// whose connection couldn't be made hasn't reached the store, so any request can be sent again.
+func retryError(req *http.Request, err error) bool {
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+	if e, ok := err.(*net.OpError); ok && e.Op == "dial" {
+		return true
+	}
+	return idempotent(req)
+}
+
+// idempotent tells if a request can be sent again after the store may have acted on it. Like
+// in net/http, a POST request counts as idempotent if it has an Idempotency-Key header, which
+// may be nil so it isn't sent.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	if _, ok := req.Header["Idempotency-Key"]; ok {
+		return true
+	}
+	_, ok := req.Header["X-Idempotency-Key"]
+	return ok
+}
+
+// retryDelay returns how long to wait before retrying. Retry-After holds seconds, which
+// Shopify sends as a decimal number like "2.0", or a date.
+func retryDelay(retryAfter string, attempt int) time.Duration {
+	if retryAfter = strings.TrimSpace(retryAfter); retryAfter != "" {
+		if seconds, err := strconv.ParseFloat(retryAfter, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			if delay := time.Until(date); delay > 0 {
+				return delay
+			}
+			return 0
+		}
+	}
+	delay := firstRetryDelay << uint(attempt)
+	if delay > maxRetryDelay || delay <= 0 {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// newHTTPTransport returns a transport like http.DefaultTransport that gives up on a store that
+// doesn't answer. The timeout applies to each attempt of a request rather than to all of them.
+func newHTTPTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		switch len(bodies) {
+		case 1:
+			w.Header().Set("Retry-After", "0.5")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	var log bytes.Buffer
+	var delays []time.Duration
+	tr := newRetryTransport(http.DefaultTransport, 5, &log)
+	tr.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	req, _ := http.NewRequest("PUT", server.URL+"/admin/metafields/1.json", strings.NewReader(`{"metafield":{}}`))
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || len(bodies) != 3 {
+		t.Fatalf("got %s after %d attempts", resp.Status, len(bodies))
+	}
+	if bodies[2] != `{"metafield":{}}` {
+		t.Errorf("the body wasn't sent again: %q", bodies[2])
+	}
+	// Retry-After is honored, otherwise the delay doubles with every retry
+	if len(delays) != 2 || delays[0] != time.Second/2 || delays[1] != 2*time.Second {
+		t.Errorf("got delays %v, want [500ms 2s]", delays)
+	}
+	if !strings.Contains(log.String(), "Retrying PUT /admin/metafields/1.json in 2s after 502 Bad Gateway (retry 2 of 5)") {
+		t.Errorf("retries weren't logged:\n%s", log.String())
+	}
+}
+
+func TestRetryTransportGivesUp(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var log bytes.Buffer
+	tr := newRetryTransport(http.DefaultTransport, 2, &log)
+	tr.sleep = func(time.Duration) {}
+	resp, err := (&http.Client{Transport: tr}).Get(server.URL + "/admin/products.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || attempts != 3 {
+		t.Errorf("got %s after %d attempts, want 503 after 3", resp.Status, attempts)
+	}
+	if !strings.Contains(log.String(), "Giving up on GET /admin/products.json after 3 attempts: 503 Service Unavailable") {
+		t.Errorf("giving up wasn't logged:\n%s", log.String())
+	}
+}
+
+func TestRetryTransportDoesNotReplayPOST(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	tr := newRetryTransport(http.DefaultTransport, 5, ioutil.Discard)
+	tr.sleep = func(time.Duration) {}
+	req, _ := http.NewRequest("POST", server.URL+"/admin/metafields.json", strings.NewReader(`{"metafield":{}}`))
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The store may have created the metafield before failing, so only the 429 is retried
+	if resp.StatusCode != http.StatusBadGateway || attempts != 2 {
+		t.Errorf("got %s after %d attempts, want 502 after 2", resp.Status, attempts)
+	}
+}
+
+func TestRetryTransportRetriesTransportErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			// Drop the connection without an answer
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	var log bytes.Buffer
+	tr := newRetryTransport(http.DefaultTransport, 2, &log)
+	tr.sleep = func(time.Duration) {}
+	resp, err := (&http.Client{Transport: tr}).Get(server.URL + "/admin/products.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || attempts != 2 {
+		t.Errorf("got %s after %d attempts, want 200 after 2", resp.Status, attempts)
+	}
+	if !strings.Contains(log.String(), "Retrying GET /admin/products.json in 1s after ") {
+		t.Errorf("the retry wasn't logged:\n%s", log.String())
+	}
+
+	// A POST may have reached the store before the connection dropped
+	attempts = 0
+	_, err = (&http.Client{Transport: tr}).Post(server.URL+"/admin/metafields.json", "application/json", strings.NewReader(`{}`))
+	if err == nil || attempts != 1 {
+		t.Errorf("got error %v after %d attempts, want an error after 1", err, attempts)
+	}
+
+	// A request the caller cancelled isn't retried
+	attempts = 1
+	log.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest("GET", server.URL+"/admin/products.json", nil)
+	if _, err := (&http.Client{Transport: tr}).Do(req.WithContext(ctx)); err == nil || log.Len() > 0 {
+		t.Errorf("got error %v and log %q, want an error and no retries", err, log.String())
+	}
+}
+
+func TestRetryTransportRetriesRefusedPOST(t *testing.T) {
+	// A closed listener leaves a port that refuses connections
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	var log bytes.Buffer
+	tr := newRetryTransport(http.DefaultTransport, 2, &log)
+	tr.sleep = func(time.Duration) {}
+	_, err := (&http.Client{Transport: tr}).Post(url+"/admin/metafields.json", "application/json", strings.NewReader(`{}`))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if strings.Count(log.String(), "Retrying POST") != 2 || !strings.Contains(log.String(), "Giving up on POST /admin/metafields.json after 3 attempts: ") {
+		t.Errorf("the retries weren't logged:\n%s", log.String())
+	}
+}
+
+func TestRetryTransportTimesOutStalledBody(t *testing.T) {
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"products":[`))
+		w.(http.Flusher).Flush()
+		<-stalled
+	}))
+	defer server.Close()
+	defer close(stalled)
+
+	tr := newRetryTransport(http.DefaultTransport, 2, ioutil.Discard)
+	tr.timeout = 100 * time.Millisecond
+	resp, err := (&http.Client{Transport: tr}).Get(server.URL + "/admin/products.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(resp.Body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("reading a stalled body didn't fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading a stalled body didn't time out")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		retryAfter string
+		attempt    int
+		want       time.Duration
+	}{
+		{"", 0, time.Second},
+		{"", 3, 8 * time.Second},
+		{"", 10, maxRetryDelay},
+		{"2.0", 0, 2 * time.Second},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", 1, 0},
+	}
+	for _, test := range tests {
+		if got := retryDelay(test.retryAfter, test.attempt); got != test.want {
+			t.Errorf("retryDelay(%q, %d) = %s, want %s", test.retryAfter, test.attempt, got, test.want)
+		}
+	}
+}
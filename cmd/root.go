@@ -21,9 +21,17 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"reflect"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dommmel/goshopping/shopify"
 	"github.com/spf13/cobra"
@@ -36,11 +44,18 @@ const colSeparator string = "<!--|col|-->"
 // init global flags
 var cfgFile, outputFile string
 
+// commandLine is the command being run, without its flags (they may hold credentials)
+var commandLine string
+
 //var debug = Debug("cli")
 
 var RootCmd = &cobra.Command{
 	Use:   "powereditor-cli",
 	Short: "powereditor-cli is a tool to export/import power-editor content from/to Shopify",
+
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		commandLine = strings.Join(append([]string{cmd.CommandPath()}, args...), " ")
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -61,6 +76,13 @@ func init() {
 	RootCmd.PersistentFlags().StringP("store", "s", "", "your shopify domain. This will override what is in your config.yml")
 	RootCmd.PersistentFlags().StringVarP(&outputFile, "output", "o", "output.json", "the file the results are written to")
 	RootCmd.PersistentFlags().StringP("namespace", "n", "power-editor", "the metafield namespace. This will override what is in your config.yml")
+	RootCmd.PersistentFlags().String("store-profile", "", "use the credentials of a store defined in the \"stores\" section of your config.yml")
+	RootCmd.PersistentFlags().Int("retries", 5, "how often a request is retried after the store answered 429 (too many requests) or 5xx")
+	viper.BindPFlag("store-profile", RootCmd.PersistentFlags().Lookup("store-profile"))
+	RootCmd.PersistentFlags().String("api", "rest", "the Shopify API to use: rest, or graphql for bulk exports and batched metafield writes")
+	viper.BindPFlag("retries", RootCmd.PersistentFlags().Lookup("retries"))
+	viper.BindPFlag("api", RootCmd.PersistentFlags().Lookup("api"))
+	RootCmd.PersistentFlags().String("api-url", "", "the base URL of the Admin API, e.g. of a \"dev fake-shop\" (default is https://<store>.myshopify.com/admin/)")
 	viper.BindPFlag("export.namespace", RootCmd.PersistentFlags().Lookup("namespace"))
 	viper.BindPFlag("import.namespace", RootCmd.PersistentFlags().Lookup("namespace"))
 	viper.BindPFlag("export.key", RootCmd.PersistentFlags().Lookup("key"))
@@ -69,6 +91,8 @@ func init() {
 	viper.BindPFlag("import.key", RootCmd.PersistentFlags().Lookup("key"))
 	viper.BindPFlag("import.password", RootCmd.PersistentFlags().Lookup("password"))
 	viper.BindPFlag("import.store", RootCmd.PersistentFlags().Lookup("store"))
+	viper.BindPFlag("export.api-url", RootCmd.PersistentFlags().Lookup("api-url"))
+	viper.BindPFlag("import.api-url", RootCmd.PersistentFlags().Lookup("api-url"))
 
 }
 
@@ -90,35 +114,107 @@ func initConfig() {
 	}
 }
 
-func checkGlobalRequiredFlags() []string {
+// StoreCredentials are the private app credentials of a single store
+type StoreCredentials struct {
+	Key      string
+	Password string
+	Store    string
+	// APIURL replaces the URL of the store's Admin API if it is set
+	APIURL string
+}
+
+// credentialFlags are the global flags that override the credentials of a store
+var credentialFlags = []string{"key", "password", "store", "api-url"}
+
+// getStoreCredentials returns the credentials used for a config section ("export" or "import").
+// Command line flags take precedence, followed by the store profile (if any) and the section itself.
+func getStoreCredentials(section string, profile string) StoreCredentials {
+	setting := func(name string) string {
+		if f := RootCmd.PersistentFlags().Lookup(name); f != nil && f.Changed {
+			return f.Value.String()
+		}
+		if profile != "" {
+			return viper.GetString("stores." + profile + "." + name)
+		}
+		return viper.GetString(section + "." + name)
+	}
+	return StoreCredentials{Key: setting("key"), Password: setting("password"), Store: setting("store"), APIURL: setting("api-url")}
+}
 
-	// Check for required API credentials
+// checkStoreCredentials returns an error message for every missing credential
+func checkStoreCredentials(section string, profile string) []string {
 	var errorMsg []string
-	if viper.GetString("export.key") == "" {
+	if profile != "" && !viper.IsSet("stores."+profile) {
+		return append(errorMsg, "store profile '"+profile+"' is not defined in the config file")
+	}
+	c := getStoreCredentials(section, profile)
+	if c.Key == "" {
 		errorMsg = append(errorMsg, "api key is required")
 	}
-	if viper.GetString("export.password") == "" {
+	if c.Password == "" {
 		errorMsg = append(errorMsg, "api password is required")
 	}
-	if viper.GetString("export.store") == "" {
+	if c.Store == "" {
 		errorMsg = append(errorMsg, "store domain is required")
 	}
+	if c.APIURL != "" {
+		if u, err := url.Parse(c.APIURL); err != nil || u.Host == "" || !strings.HasSuffix(u.Path, "/") {
+			errorMsg = append(errorMsg, fmt.Sprintf("api url '%s' is not valid, it should look like http://localhost:8080/admin/", c.APIURL))
+		}
+	}
 	return errorMsg
 }
 
-func GetClient() *shopify.Client {
-	return shopify.NewPrivateClient(nil, viper.GetString("export.key"), viper.GetString("export.password"), viper.GetString("export.store"))
+// checkGlobalRequiredFlags checks the API credentials and the file format of a config section ("export" or "import")
+func checkGlobalRequiredFlags(section string) []string {
+	errorMsg := append(checkStoreCredentials(section, viper.GetString("store-profile")), checkFormat(section)...)
+	return append(errorMsg, checkAPI()...)
+}
+
+// GetStore returns the store of a config section ("export" or "import")
+func GetStore(section string) Store {
+	return NewStore(getStoreCredentials(section, viper.GetString("store-profile")))
+}
+
+// NewClient returns a client for the store with the given credentials. Its requests
+// stay within the store's API call limit, even when sent concurrently, and failed
+// requests are retried as often as set with --retries. With c.APIURL, they go to that URL
+// instead of the store, e.g. to a fake shop.
+func NewClient(c StoreCredentials) *shopify.Client {
+	transport := newRetryTransport(newCallLimitTransport(newHTTPTransport()), viper.GetInt("retries"), os.Stderr)
+	client := shopify.NewPrivateClient(&http.Client{Transport: transport}, c.Key, c.Password, c.Store)
+	if apiURL, err := url.Parse(c.APIURL); err == nil && apiURL.Host != "" {
+		apiURL.User = client.BaseURL.User
+		client.BaseURL = apiURL
+	}
+	return client
+}
+
+// bindFlags binds the flags of a command to the config keys of a section ("export" or "import").
+// Several commands share the same keys, e.g. "export collection", "export products" and "sync"
+// all have --include-variants, and viper only keeps the last flag bound to a key. So commands
+// bind their flags in PreRunE, once it's clear which of them is run.
+func bindFlags(cmd *cobra.Command, section string, names ...string) {
+	for _, name := range names {
+		viper.BindPFlag(section+"."+name, cmd.Flags().Lookup(name))
+	}
+}
+
+// requiredFlagsError combines the messages of failed checks into a single error
+func requiredFlagsError(errorMsg []string) error {
+	if len(errorMsg) == 1 {
+		return errors.New(errorMsg[0])
+	} else if len(errorMsg) > 0 {
+		return errors.New("\n - " + strings.Join(errorMsg, "\n - "))
+	}
+	return nil
 }
 
 func getSliceOfMapValue(m map[string]string) []string {
 	// Preserve Order of map entries
 	// See: https://blog.golang.org/go-maps-in-action#TOC_7.
-	var keys []string
-	for k := range m {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
 	var v []string
+	keys := sortedNumericKeys(m)
 
 	for _, k := range keys {
 		v = append(v, m[k])
@@ -126,19 +222,125 @@ func getSliceOfMapValue(m map[string]string) []string {
 	return v
 }
 
+// sortedNumericKeys returns the keys "0", "1", ... "10" of a map in numeric order.
+// Keys that aren't numbers are sorted after the numeric ones.
+func sortedNumericKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.Atoi(keys[i])
+		b, errB := strconv.Atoi(keys[j])
+		switch {
+		case errA == nil && errB == nil:
+			return a < b
+		case errA == nil || errB == nil:
+			return errA == nil
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
 /* DATA FORMAT */
 
+// FormatVersion is the version of the data format written by exports.
+// Older files are upgraded by the migrations in migrate.go.
+const FormatVersion = 3
+
 type Output struct {
-	Products []*ProductOutput `json:"products"`
+	Header      *Header             `json:"header"`
+	Products    []*ProductOutput    `json:"products,omitempty"`
+	Pages       []*PageOutput       `json:"pages,omitempty"`
+	Articles    []*ArticleOutput    `json:"articles,omitempty"`
+	Collections []*CollectionOutput `json:"collections,omitempty"`
+	Shop        *ShopOutput         `json:"shop,omitempty"`
+}
+
+// Header tells where and when a data file was created
+type Header struct {
+	FormatVersion int        `json:"format_version"`
+	Store         string     `json:"store,omitempty"`
+	Namespace     string     `json:"namespace,omitempty"`
+	Command       string     `json:"command,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+}
+
+// newOutput returns empty output data with a header for the given store and namespace
+func newOutput(store Store, namespace string) Output {
+	now := time.Now().UTC().Truncate(time.Second)
+	return Output{Header: &Header{
+		FormatVersion: FormatVersion,
+		Store:         store.Name(),
+		Namespace:     namespace,
+		Command:       commandLine,
+		CreatedAt:     &now,
+	}}
+}
+
+// contentOutput is implemented by the exported records of all resources with power-editor content
+type contentOutput interface {
+	content() *content
+	outputFields() []*OutputField
 }
 
 type OutputField struct {
-	Id   *int                         `json:"id"`
-	Key  *string                      `json:"key"`
-	Data map[string]map[string]string `json:"data"`
+	Id   *int      `json:"id"`
+	Key  *string   `json:"key"`
+	Data FieldData `json:"data"`
+}
+
+// FieldData holds the rows of a field, each row being a list of columns
+type FieldData [][]string
+
+// UnmarshalJSON reads the rows as arrays (format version 2) as well as
+// objects keyed by row and column number (format version 1).
+func (d *FieldData) UnmarshalJSON(b []byte) error {
+	var rows [][]string
+	if err := json.Unmarshal(b, &rows); err == nil {
+		*d = rows
+		return nil
+	}
+
+	var v1 map[string]map[string]string
+	if err := json.Unmarshal(b, &v1); err != nil {
+		return err
+	}
+	*d = nil
+	for _, k := range sortedNumericKeys(v1) {
+		*d = append(*d, getSliceOfMapValue(v1[k]))
+	}
+	return nil
 }
 
 type ProductOutput struct {
+	Id                             *int             `json:"id"`
+	Handle                         *string          `json:"handle"`
+	BodyHtml                       *string          `json:"body_html,omitempty"`
+	Title                          *string          `json:"title,omitempty"`
+	MetafieldsGlobalTitleTag       *string          `json:"metafields_global_title_tag,omitempty"`
+	MetafieldsGlobalDescriptionTag *string          `json:"metafields_global_description_tag,omitempty"`
+	Fields                         []*OutputField   `json:"fields,omitempty"`
+	Variants                       []*VariantOutput `json:"variants,omitempty"`
+}
+
+// VariantOutput holds the power-editor content of a variant. Variant IDs differ between
+// stores, so SKU and option values are exported to match the variant on import.
+type VariantOutput struct {
+	Id      *int           `json:"id"`
+	Sku     *string        `json:"sku,omitempty"`
+	Title   *string        `json:"title,omitempty"`
+	Options []string       `json:"options,omitempty"`
+	Fields  []*OutputField `json:"fields,omitempty"`
+}
+
+// ShopOutput holds the power-editor metafields of the shop itself
+type ShopOutput struct {
+	Fields []*OutputField `json:"fields,omitempty"`
+}
+
+type PageOutput struct {
 	Id                             *int           `json:"id"`
 	Handle                         *string        `json:"handle"`
 	BodyHtml                       *string        `json:"body_html,omitempty"`
@@ -147,3 +349,155 @@ type ProductOutput struct {
 	MetafieldsGlobalDescriptionTag *string        `json:"metafields_global_description_tag,omitempty"`
 	Fields                         []*OutputField `json:"fields,omitempty"`
 }
+
+type ArticleOutput struct {
+	Id                             *int           `json:"id"`
+	BlogId                         *int           `json:"blog_id"`
+	BlogHandle                     *string        `json:"blog_handle"`
+	Handle                         *string        `json:"handle"`
+	BodyHtml                       *string        `json:"body_html,omitempty"`
+	Title                          *string        `json:"title,omitempty"`
+	MetafieldsGlobalTitleTag       *string        `json:"metafields_global_title_tag,omitempty"`
+	MetafieldsGlobalDescriptionTag *string        `json:"metafields_global_description_tag,omitempty"`
+	Fields                         []*OutputField `json:"fields,omitempty"`
+}
+
+// CollectionOutput is a custom or a smart collection, as told by CollectionType
+type CollectionOutput struct {
+	Id                             *int           `json:"id"`
+	CollectionType                 *string        `json:"collection_type"`
+	Handle                         *string        `json:"handle"`
+	BodyHtml                       *string        `json:"body_html,omitempty"`
+	Title                          *string        `json:"title,omitempty"`
+	MetafieldsGlobalTitleTag       *string        `json:"metafields_global_title_tag,omitempty"`
+	MetafieldsGlobalDescriptionTag *string        `json:"metafields_global_description_tag,omitempty"`
+	Fields                         []*OutputField `json:"fields,omitempty"`
+}
+
+func (p *ProductOutput) content() *content {
+	return &content{
+		Id:                             p.Id,
+		Handle:                         p.Handle,
+		Title:                          p.Title,
+		BodyHtml:                       p.BodyHtml,
+		MetafieldsGlobalTitleTag:       p.MetafieldsGlobalTitleTag,
+		MetafieldsGlobalDescriptionTag: p.MetafieldsGlobalDescriptionTag,
+	}
+}
+
+func (p *ProductOutput) outputFields() []*OutputField {
+	return p.Fields
+}
+
+func (p *PageOutput) content() *content {
+	return &content{
+		Id:                             p.Id,
+		Handle:                         p.Handle,
+		Title:                          p.Title,
+		BodyHtml:                       p.BodyHtml,
+		MetafieldsGlobalTitleTag:       p.MetafieldsGlobalTitleTag,
+		MetafieldsGlobalDescriptionTag: p.MetafieldsGlobalDescriptionTag,
+	}
+}
+
+func (p *PageOutput) outputFields() []*OutputField {
+	return p.Fields
+}
+
+func (a *ArticleOutput) content() *content {
+	return &content{
+		Id:                             a.Id,
+		Handle:                         a.Handle,
+		Title:                          a.Title,
+		BodyHtml:                       a.BodyHtml,
+		MetafieldsGlobalTitleTag:       a.MetafieldsGlobalTitleTag,
+		MetafieldsGlobalDescriptionTag: a.MetafieldsGlobalDescriptionTag,
+	}
+}
+
+func (a *ArticleOutput) outputFields() []*OutputField {
+	return a.Fields
+}
+
+func (c *CollectionOutput) content() *content {
+	return &content{
+		Id:                             c.Id,
+		Handle:                         c.Handle,
+		Title:                          c.Title,
+		BodyHtml:                       c.BodyHtml,
+		MetafieldsGlobalTitleTag:       c.MetafieldsGlobalTitleTag,
+		MetafieldsGlobalDescriptionTag: c.MetafieldsGlobalDescriptionTag,
+	}
+}
+
+func (c *CollectionOutput) outputFields() []*OutputField {
+	return c.Fields
+}
+
+// kind returns the resource kind of the collection, custom collections being the default
+func (c *CollectionOutput) kind() string {
+	if c.CollectionType != nil && *c.CollectionType == "smart" {
+		return kindSmartCollection
+	}
+	return kindCustomCollection
+}
+
+func (s *ShopOutput) content() *content {
+	return &content{}
+}
+
+func (s *ShopOutput) outputFields() []*OutputField {
+	return s.Fields
+}
+
+func newProductOutput(c *content, fields []*OutputField) *ProductOutput {
+	return &ProductOutput{
+		Id:                             c.Id,
+		Handle:                         c.Handle,
+		Title:                          c.Title,
+		BodyHtml:                       c.BodyHtml,
+		MetafieldsGlobalTitleTag:       c.MetafieldsGlobalTitleTag,
+		MetafieldsGlobalDescriptionTag: c.MetafieldsGlobalDescriptionTag,
+		Fields:                         fields,
+	}
+}
+
+func newPageOutput(c *content, fields []*OutputField) *PageOutput {
+	return &PageOutput{
+		Id:                             c.Id,
+		Handle:                         c.Handle,
+		Title:                          c.Title,
+		BodyHtml:                       c.BodyHtml,
+		MetafieldsGlobalTitleTag:       c.MetafieldsGlobalTitleTag,
+		MetafieldsGlobalDescriptionTag: c.MetafieldsGlobalDescriptionTag,
+		Fields:                         fields,
+	}
+}
+
+func newArticleOutput(blog *content, c *content, fields []*OutputField) *ArticleOutput {
+	return &ArticleOutput{
+		Id:                             c.Id,
+		BlogId:                         blog.Id,
+		BlogHandle:                     blog.Handle,
+		Handle:                         c.Handle,
+		Title:                          c.Title,
+		BodyHtml:                       c.BodyHtml,
+		MetafieldsGlobalTitleTag:       c.MetafieldsGlobalTitleTag,
+		MetafieldsGlobalDescriptionTag: c.MetafieldsGlobalDescriptionTag,
+		Fields:                         fields,
+	}
+}
+
+func newCollectionOutput(kind string, c *content, fields []*OutputField) *CollectionOutput {
+	collectionType := strings.TrimSuffix(kind, "_collection")
+	return &CollectionOutput{
+		Id:                             c.Id,
+		CollectionType:                 &collectionType,
+		Handle:                         c.Handle,
+		Title:                          c.Title,
+		BodyHtml:                       c.BodyHtml,
+		MetafieldsGlobalTitleTag:       c.MetafieldsGlobalTitleTag,
+		MetafieldsGlobalDescriptionTag: c.MetafieldsGlobalDescriptionTag,
+		Fields:                         fields,
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestGetStoreCredentials(t *testing.T) {
+	viper.Set("import.key", "section-key")
+	viper.Set("import.store", "section-store")
+	viper.Set("stores.live", map[string]interface{}{"key": "live-key", "store": "live-store"})
+	defer viper.Set("import.key", nil)
+	defer viper.Set("import.store", nil)
+	defer viper.Set("stores.live", nil)
+
+	cases := []struct {
+		name    string
+		flag    string
+		profile string
+		key     string
+		store   string
+		err     string
+	}{
+		{name: "section", key: "section-key", store: "section-store"},
+		{name: "flag", flag: "flag-key", key: "flag-key", store: "section-store"},
+		{name: "profile", profile: "live", key: "live-key", store: "live-store"},
+		{name: "flag and profile", flag: "flag-key", profile: "live", key: "flag-key", store: "live-store"},
+		{name: "missing profile", profile: "staging", err: "store profile 'staging' is not defined"},
+	}
+	for _, c := range cases {
+		key := RootCmd.PersistentFlags().Lookup("key")
+		if c.flag != "" {
+			key.Value.Set(c.flag)
+			key.Changed = true
+		}
+
+		got := getStoreCredentials("import", c.profile)
+		errorMsg := strings.Join(checkStoreCredentials("import", c.profile), ", ")
+		key.Value.Set("")
+		key.Changed = false
+
+		if c.err != "" {
+			if !strings.Contains(errorMsg, c.err) {
+				t.Errorf("%s: got errors %q", c.name, errorMsg)
+			}
+			continue
+		}
+		if got.Key != c.key || got.Store != c.store {
+			t.Errorf("%s: got key %q and store %q, want %q and %q", c.name, got.Key, got.Store, c.key, c.store)
+		}
+	}
+}
+
+func TestSyncRejectsCredentialFlags(t *testing.T) {
+	store := RootCmd.PersistentFlags().Lookup("store")
+	store.Value.Set("my-live-store")
+	store.Changed = true
+	defer func() {
+		store.Value.Set("")
+		store.Changed = false
+	}()
+
+	err := syncCollectionCmd.PreRunE(syncCollectionCmd, []string{"12"})
+	if err == nil || !strings.Contains(err.Error(), "--store can't be used with sync") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"github.com/caarlos0/spin"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// shopCmd represents the "export shop" command
+var shopCmd = &cobra.Command{
+	Use:   "shop",
+	Short: "export the power-editor metafields of the shop itself",
+
+	// Do all the error handling pre run
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check for required API credentials
+		return requiredFlagsError(checkGlobalRequiredFlags("export"))
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := GetStore("export")
+
+		s := spin.New("  \033[36m Fetching shop metafields \033[m %s")
+		s.Set(spin.Spin1)
+		s.Start()
+		ref := contentRef{kind: kindShop}
+		metafields, err := store.ListMetafields(ref, viper.GetString("export.namespace"))
+		s.Stop()
+		if err != nil {
+			return err
+		}
+
+		output := newOutput(store, viper.GetString("export.namespace"))
+		output.Shop = &ShopOutput{Fields: GenerateProductDataOutput(metafields)}
+		return writeExport(&output)
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(shopCmd)
+}
@@ -0,0 +1,143 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dommmel/goshopping/shopify"
+)
+
+// Store is a shop that power-editor content is exported from and imported into. The commands
+// work with any store: the REST API (restStore), the GraphQL API (graphqlStore) or the
+// in-memory store (memoryStore).
+type Store interface {
+	// Name returns the domain of the store, which is recorded in the header of data files
+	Name() string
+
+	// ListProducts returns all products that match the options
+	ListProducts(opt *shopify.ProductListOptions) ([]*shopify.Product, error)
+	// ListContent returns the pages, blogs, collections or articles of the blog parentId
+	// that match the options
+	ListContent(kind string, parentId int, opt *contentListOptions) ([]*content, error)
+	// GetContent returns the shared content properties of a resource
+	GetContent(ref contentRef) (*content, error)
+	// EditContent writes the title, body and SEO tags of c to a resource. Properties
+	// that are nil are left untouched.
+	EditContent(ref contentRef, c *content) error
+
+	// ListMetafields returns the metafields of a namespace attached to a resource
+	ListMetafields(ref contentRef, namespace string) ([]*shopify.Metafield, error)
+	// SetMetafields creates the given metafields that have no ID and updates the values of the others
+	SetMetafields(ref contentRef, namespace string, metafields []*shopify.Metafield) error
+	// DeleteMetafields deletes metafields of a resource
+	DeleteMetafields(ref contentRef, metafields []*shopify.Metafield) error
+}
+
+// bulkExporter is implemented by stores that fetch all products of an export at once. The
+// products are those of the collection, or those matching the filter if collectionId is 0.
+// resumeAt returns the index of the first product to export.
+type bulkExporter interface {
+	ExportProducts(filter *ProductFilter, collectionId int, resumeAt func(ids []*int) (int, error), emit func(*ProductOutput) error) error
+}
+
+// NewStore returns the store with the given credentials, using the API chosen with --api
+func NewStore(c StoreCredentials) Store {
+	rest := &restStore{client: NewClient(c)}
+	if useGraphQL() {
+		return &graphqlStore{restStore: rest}
+	}
+	return rest
+}
+
+// restStore is a store accessed through the REST Admin API
+type restStore struct {
+	client *shopify.Client
+}
+
+func (s *restStore) Name() string {
+	return s.client.BaseURL.Host
+}
+
+func (s *restStore) ListProducts(opt *shopify.ProductListOptions) ([]*shopify.Product, error) {
+	return s.client.Products.AutoPagingList(context.Background(), opt)
+}
+
+func (s *restStore) ListContent(kind string, parentId int, opt *contentListOptions) ([]*content, error) {
+	path := kind + "s.json"
+	if kind == kindArticle {
+		path = fmt.Sprintf("blogs/%d/articles.json", parentId)
+	}
+	return listContent(path, kind+"s", opt, s.client)
+}
+
+func (s *restStore) GetContent(ref contentRef) (*content, error) {
+	return getContent(ref, s.client)
+}
+
+func (s *restStore) EditContent(ref contentRef, c *content) error {
+	return editContent(ref, c, s.client)
+}
+
+func (s *restStore) ListMetafields(ref contentRef, namespace string) ([]*shopify.Metafield, error) {
+	metafields, err := listMetafields(ref.owner(), namespace, s.client)
+	for _, m := range metafields {
+		m.Namespace = &namespace
+	}
+	return metafields, err
+}
+
+// SetMetafields writes one metafield after the other, the REST API has no batch endpoint
+func (s *restStore) SetMetafields(ref contentRef, namespace string, metafields []*shopify.Metafield) error {
+	var errorMsg []string
+	for _, m := range metafields {
+		var err error
+		if m.Id != nil {
+			err = updateMetafield(ref.owner(), *m.Id, m.Value, s.client)
+		} else {
+			valueType := "string"
+			err = createMetafield(ref.owner(), &shopify.Metafield{Namespace: &namespace, Key: m.Key, Value: m.Value, ValueType: &valueType}, s.client)
+		}
+		if err != nil {
+			errorMsg = append(errorMsg, fmt.Sprintf("metafield %s: %s", *m.Key, err))
+		}
+	}
+	if len(errorMsg) > 0 {
+		return errors.New(strings.Join(errorMsg, ", "))
+	}
+	return nil
+}
+
+func (s *restStore) DeleteMetafields(ref contentRef, metafields []*shopify.Metafield) error {
+	var errorMsg []string
+	for _, m := range metafields {
+		if _, err := s.client.Metafields.Delete(context.Background(), *m.Id); err != nil {
+			errorMsg = append(errorMsg, fmt.Sprintf("metafield %s: %s", *m.Key, err))
+		}
+	}
+	if len(errorMsg) > 0 {
+		return errors.New(strings.Join(errorMsg, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/viper"
+)
+
+func TestMemoryStoreListProducts(t *testing.T) {
+	store := newMemoryStore("test.myshopify.com")
+	store.addProduct(&shopify.Product{Handle: strPtr("ball"), Title: strPtr("Ball"), Vendor: strPtr("Blackroll")}, 7)
+	store.addProduct(&shopify.Product{Handle: strPtr("mat"), Title: strPtr("Mat")})
+
+	products, err := store.ListProducts(&shopify.ProductListOptions{CollectionId: 7, Fields: []string{"id", "handle"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 || *products[0].Handle != "ball" || products[0].Title != nil {
+		t.Errorf("got %+v", products)
+	}
+	products, _ = store.ListProducts(&shopify.ProductListOptions{Handle: "mat,ball"})
+	if len(products) != 2 {
+		t.Errorf("got %d products by handle", len(products))
+	}
+	products, _ = store.ListProducts(&shopify.ProductListOptions{Vendor: "Other"})
+	if len(products) != 0 {
+		t.Errorf("got %d products of another vendor", len(products))
+	}
+}
+
+func TestExportImportWithMemoryStore(t *testing.T) {
+	for key, value := range map[string]interface{}{
+		"export.namespace":            "power-editor",
+		"export.include-product-info": true,
+		"export.include-variants":     true,
+		"import.namespace":            "power-editor",
+		"import.primary-key":          "handle",
+		"import.prune":                true,
+	} {
+		viper.Set(key, value)
+		defer viper.Set(key, nil)
+	}
+
+	source := newMemoryStore("source.myshopify.com")
+	p := source.addProduct(&shopify.Product{
+		Handle: strPtr("blackroll-med-45"), Title: strPtr("BLACKROLL® MED 45"), BodyHtml: strPtr("<p>soft</p>"),
+		Variants: []*shopify.Variant{{Sku: strPtr("BR-45"), Option1: strPtr("45 cm")}},
+	})
+	productRef := contentRef{kind: kindProduct, id: *p.Id}
+	source.addMetafield(productRef, "power-editor", "tabs", "a"+colSeparator+"b"+rowSeparator+"c")
+	source.addMetafield(productRef, "global", "title_tag", "Soft roll")
+	source.addMetafield(contentRef{kind: kindVariant, id: *p.Variants[0].Id, parentId: *p.Id}, "power-editor", "size", "45 cm")
+
+	var exported []*ProductOutput
+	products, err := source.ListProducts(&shopify.ProductListOptions{Fields: exportProductFields()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exportProducts(products, source, func(pout *ProductOutput) error {
+		exported = append(exported, pout)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 1 || len(exported[0].Fields) != 1 || len(exported[0].Variants) != 1 {
+		t.Fatalf("got %d products", len(exported))
+	}
+
+	// The product has a different ID and an outdated metafield in the destination
+	destination := newMemoryStore("destination.myshopify.com")
+	q := destination.addProduct(&shopify.Product{
+		Id: intPtr(1000), Handle: strPtr("blackroll-med-45"), Title: strPtr("Old title"),
+		Variants: []*shopify.Variant{{Sku: strPtr("BR-45"), Option1: strPtr("45 cm")}},
+	})
+	destination.addMetafield(contentRef{kind: kindProduct, id: 1000}, "power-editor", "video", "XGBQkxcM8DI")
+
+	id, err := resolveProductId(exported[0], destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := importContent(contentRef{kind: kindProduct, id: *id}, exported[0], destination); err != nil {
+		t.Fatal(err)
+	}
+
+	ref := contentRef{kind: kindProduct, id: 1000}
+	metafields, _ := destination.ListMetafields(ref, "power-editor")
+	if len(metafields) != 1 || *metafields[0].Key != "tabs" || *metafields[0].Value != "a"+colSeparator+"b"+rowSeparator+"c" {
+		t.Errorf("got metafields %v", metafields)
+	}
+	if c, _ := destination.GetContent(ref); *c.Title != "BLACKROLL® MED 45" {
+		t.Errorf("got title %s", *c.Title)
+	}
+	if titleTag, _, _ := getSeoTags(ref, destination); titleTag == nil || *titleTag != "Soft roll" {
+		t.Errorf("got title tag %v", titleTag)
+	}
+	variantFields, _ := destination.ListMetafields(contentRef{kind: kindVariant, id: *q.Variants[0].Id, parentId: 1000}, "power-editor")
+	if len(variantFields) != 1 || *variantFields[0].Value != "45 cm" {
+		t.Errorf("got variant metafields %v", variantFields)
+	}
+}
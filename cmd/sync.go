@@ -0,0 +1,146 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/caarlos0/spin"
+	"github.com/spf13/cobra"
+)
+
+var syncFrom, syncTo string
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Copy power-editor content from one store to another",
+}
+
+// syncCollectionCmd represents the "sync collection" command
+var syncCollectionCmd = &cobra.Command{
+	Use:   "collection <collection id>",
+	Short: "copy the power-editor content of a collection and its products to another store",
+
+	// Do all the error handling pre run
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		bindFlags(cmd, "export", "include-product-info", "include-variants")
+		bindFlags(cmd, "import", "metafields-only", "dry-run", "prune", "backup-dir", "no-backup")
+
+		// Credentials on the command line would apply to both stores
+		var errorMsg []string
+		for _, name := range credentialFlags {
+			if RootCmd.PersistentFlags().Lookup(name).Changed {
+				errorMsg = append(errorMsg, fmt.Sprintf("--%s can't be used with sync, it would apply to both stores. Use --from and --to instead", name))
+			}
+		}
+
+		// Check for required API credentials of both stores
+		errorMsg = append(errorMsg, checkStoreCredentials("export", syncFrom)...)
+		for _, msg := range checkStoreCredentials("import", syncTo) {
+			errorMsg = append(errorMsg, "destination: "+msg)
+		}
+
+		// Check for required collection ID
+		if len(args) < 1 {
+			errorMsg = append(errorMsg, "a collection ID is required as an argument")
+		} else {
+			var err error
+			if collectionId, err = strconv.Atoi(args[0]); err != nil {
+				errorMsg = append(errorMsg, fmt.Sprintf("'%s' is not a valid collection ID", args[0]))
+			}
+		}
+
+		return requiredFlagsError(errorMsg)
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		source := NewStore(getStoreCredentials("export", syncFrom))
+		destination := NewStore(getStoreCredentials("import", syncTo))
+
+		s := spin.New("  \033[36m Scanning collection \033[m %s")
+		s.Set(spin.Spin1)
+		s.Start()
+		products, err := GetProductsByCollection(collectionId, source)
+		s.Stop()
+		if err != nil {
+			return err
+		}
+
+		// Product IDs differ between stores, so products are matched by handle
+		im := newImporter(destination)
+		err = exportProducts(products, source, func(p *ProductOutput) error {
+			productId, err := getProductIdByProperty("handle", *p.Handle, destination)
+			if err != nil {
+				fmt.Printf("Skipping: %s\n", err)
+				return nil
+			}
+
+			plan, err := im.add(contentRef{kind: kindProduct, id: *productId}, p)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Can't update product %s: %s\n", *p.Handle, err)
+			}
+			if plan != nil {
+				plan.Print(os.Stdout)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Copy the content of the collection itself
+		collection, err := exportCollectionRecord(collectionId, source)
+		if err != nil {
+			return err
+		}
+		kind := collection.kind()
+		id, err := findContentId(kind, 0, "handle", *collection.Handle, destination)
+		if err != nil {
+			fmt.Printf("Skipping: %s\n", err)
+		} else {
+			plan, err := im.add(contentRef{kind: kind, id: id}, collection)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Can't update collection %s: %s\n", *collection.Handle, err)
+			}
+			if plan != nil {
+				plan.Print(os.Stdout)
+			}
+		}
+		return im.finish()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(syncCmd)
+	syncCmd.AddCommand(syncCollectionCmd)
+	syncCmd.PersistentFlags().StringVar(&syncFrom, "from", "", `the store profile to copy from (default is the "export" section of your config.yml)`)
+	syncCmd.PersistentFlags().StringVar(&syncTo, "to", "", `the store profile to copy to (default is the "import" section of your config.yml)`)
+	syncCollectionCmd.Flags().BoolP("include-product-info", "i", false, "Include product content (titles, descriptions)")
+	syncCollectionCmd.Flags().BoolP("include-variants", "V", false, "Include the power-editor metafields of variants")
+	syncCollectionCmd.Flags().BoolP("metafields-only", "m", false, "Don't import product titles or descriptions")
+	syncCollectionCmd.Flags().BoolP("dry-run", "d", false, "Do not import but show a list of updates that would happen")
+	syncCollectionCmd.Flags().Bool("prune", false, "Delete metafields in the namespace that are not in the source store")
+	syncCollectionCmd.Flags().String("backup-dir", ".", "the directory the backup of the destination products is written to")
+	syncCollectionCmd.Flags().Bool("no-backup", false, "Don't write a backup of the destination products")
+}
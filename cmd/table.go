@@ -0,0 +1,305 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The tabular formats (CSV, XLSX) write a record as rows of cells. identityColumns tell which
+// record a row belongs to, contentColumns hold its content and fieldColumns one row of a field,
+// whose columns are in the numbered columns "0", "1", ... that follow.
+var (
+	identityColumns = []string{"kind", "id", "handle", "parent_id", "parent_handle", "sku", "options"}
+	contentColumns  = []string{"title", "body_html", "metafields_global_title_tag", "metafields_global_description_tag"}
+	fieldColumns    = []string{"field", "row", "cols"}
+)
+
+// kindHeader marks the rows holding a value of the header of a data file rather than a record.
+// The name of the value is in the field column and the value in column "0".
+const kindHeader = "header"
+
+// optionSeparator joins the option values of a variant in a single cell
+const optionSeparator = " / "
+
+// tableHeader returns the names of the given columns followed by width numbered columns
+func tableHeader(width int, columns ...[]string) []string {
+	var header []string
+	for _, c := range columns {
+		header = append(header, c...)
+	}
+	for i := 0; i < width; i++ {
+		header = append(header, strconv.Itoa(i))
+	}
+	return header
+}
+
+// fieldWidth returns the largest number of columns of the given fields
+func fieldWidth(fields []*OutputField) int {
+	width := 0
+	for _, f := range fields {
+		for _, row := range f.Data {
+			if len(row) > width {
+				width = len(row)
+			}
+		}
+	}
+	return width
+}
+
+// headerRows returns a row for every value of a header that is set, with the given columns
+func headerRows(h *Header, columns []string) [][]string {
+	index := make(map[string]int)
+	for i, c := range columns {
+		index[c] = i
+	}
+	var rows [][]string
+	for _, name := range headerNames {
+		if value := headerValue(h, name); value != "" {
+			row := make([]string, len(columns))
+			row[index["kind"]], row[index["field"]], row[index["0"]] = kindHeader, name, value
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// identityCells returns the cells of the identityColumns of a record
+func identityCells(r *flatRecord) []string {
+	cells := []string{r.kind, formatIntPtr(r.content.Id), stringValue(r.content.Handle), "", ""}
+	if r.parent != nil {
+		cells[3], cells[4] = formatIntPtr(r.parent.Id), stringValue(r.parent.Handle)
+	}
+	return append(cells, stringValue(r.sku), strings.Join(r.options, optionSeparator))
+}
+
+// contentCells returns the cells of the contentColumns of a record
+func contentCells(r *flatRecord) []string {
+	c := r.content
+	return []string{stringValue(c.Title), stringValue(c.BodyHtml), stringValue(c.MetafieldsGlobalTitleTag), stringValue(c.MetafieldsGlobalDescriptionTag)}
+}
+
+// fieldCells returns the fieldColumns and numbered columns of every row of a field.
+// A field without rows is written as a single empty row.
+func fieldCells(f *OutputField) [][]string {
+	rows := f.Data
+	if len(rows) == 0 {
+		rows = [][]string{{}}
+	}
+	var lines [][]string
+	for i, row := range rows {
+		lines = append(lines, append([]string{stringValue(f.Key), strconv.Itoa(i), strconv.Itoa(len(row))}, row...))
+	}
+	return lines
+}
+
+// tableReader puts records back together from tables of rows. Columns are found by
+// their header, so they may be reordered. Rows belong to the same record if they have
+// the same kind, id, handle, parent and SKU, and field rows are ordered by their row number.
+type tableReader struct {
+	header     *Header
+	records    []*flatRecord
+	byIdentity map[string]*flatRecord
+	fieldRows  map[*OutputField]map[int][]string
+}
+
+func newTableReader() *tableReader {
+	return &tableReader{
+		header:     &Header{},
+		byIdentity: make(map[string]*flatRecord),
+		fieldRows:  make(map[*OutputField]map[int][]string),
+	}
+}
+
+// add reads a table whose first row is the header. Rows of kind "header" hold a value
+// of the header of the data file, rows without a field the content of a record and the
+// others a row of one of its fields.
+func (t *tableReader) add(name string, table [][]string) error {
+	if len(table) == 0 {
+		return nil
+	}
+	column := make(map[string]int)
+	for i, c := range table[0] {
+		column[strings.TrimSpace(c)] = i
+	}
+	if _, ok := column["kind"]; !ok {
+		return fmt.Errorf("%s: column 'kind' is missing", name)
+	}
+
+	for n, cells := range table[1:] {
+		line := n + 2
+		cell := func(name string) string {
+			if i, ok := column[name]; ok && i < len(cells) {
+				return cells[i]
+			}
+			return ""
+		}
+		switch cell("kind") {
+		case "":
+			continue
+		case kindHeader:
+			if err := setHeaderValue(t.header, cell("field"), cell("0")); err != nil {
+				return fmt.Errorf("%s line %d: %v", name, line, err)
+			}
+			continue
+		}
+
+		record, err := t.record(cell)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %v", name, line, err)
+		}
+
+		key := cell("field")
+		if key == "" {
+			c := record.content
+			c.Title = optionalString(cell("title"))
+			c.BodyHtml = optionalString(cell("body_html"))
+			c.MetafieldsGlobalTitleTag = optionalString(cell("metafields_global_title_tag"))
+			c.MetafieldsGlobalDescriptionTag = optionalString(cell("metafields_global_description_tag"))
+			continue
+		}
+
+		rowIndex, err := strconv.Atoi(cell("row"))
+		if err != nil {
+			return fmt.Errorf("%s line %d: invalid row number '%s'", name, line, cell("row"))
+		}
+		var row []string
+		for i := 0; ; i++ {
+			if _, ok := column[strconv.Itoa(i)]; !ok {
+				break
+			}
+			row = append(row, cell(strconv.Itoa(i)))
+		}
+		if cols, err := strconv.Atoi(cell("cols")); err == nil {
+			// Cells filled in beyond the number of columns are kept rather than dropped
+			for len(row) > cols && row[len(row)-1] == "" {
+				row = row[:len(row)-1]
+			}
+			row = pad(row, cols)
+		} else {
+			// Rows added by hand may lack the number of columns
+			for len(row) > 0 && row[len(row)-1] == "" {
+				row = row[:len(row)-1]
+			}
+		}
+		t.field(record, key)[rowIndex] = row
+	}
+	return nil
+}
+
+// record returns the record a row belongs to, adding it if it is new
+func (t *tableReader) record(cell func(string) string) (*flatRecord, error) {
+	identity := strings.Join([]string{cell("kind"), cell("id"), cell("handle"), cell("parent_id"), cell("parent_handle"), cell("sku")}, "\x00")
+	if record := t.byIdentity[identity]; record != nil {
+		return record, nil
+	}
+
+	var err error
+	record := &flatRecord{kind: cell("kind"), content: &content{}, sku: optionalString(cell("sku"))}
+	if record.content.Id, err = parseIntPtr(cell("id")); err != nil {
+		return nil, fmt.Errorf("invalid id: %v", err)
+	}
+	record.content.Handle = optionalString(cell("handle"))
+	if cell("parent_id") != "" || cell("parent_handle") != "" {
+		record.parent = &content{Handle: optionalString(cell("parent_handle"))}
+		if record.parent.Id, err = parseIntPtr(cell("parent_id")); err != nil {
+			return nil, fmt.Errorf("invalid parent_id: %v", err)
+		}
+	}
+	if options := cell("options"); options != "" {
+		record.options = strings.Split(options, optionSeparator)
+	}
+	t.byIdentity[identity] = record
+	t.records = append(t.records, record)
+	return record, nil
+}
+
+// field returns the rows of a field of record by row number, adding the field if it is new
+func (t *tableReader) field(record *flatRecord, key string) map[int][]string {
+	for _, f := range record.fields {
+		if *f.Key == key {
+			return t.fieldRows[f]
+		}
+	}
+	f := &OutputField{Key: &key}
+	record.fields = append(record.fields, f)
+	t.fieldRows[f] = make(map[int][]string)
+	return t.fieldRows[f]
+}
+
+// output returns the data file made of all tables read
+func (t *tableReader) output() (*Output, error) {
+	for field, rows := range t.fieldRows {
+		indexes := make([]int, 0, len(rows))
+		for i := range rows {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		for _, i := range indexes {
+			field.Data = append(field.Data, rows[i])
+		}
+	}
+	return unflattenOutput(t.header, t.records)
+}
+
+// pad extends a row with empty cells to the given length
+func pad(row []string, length int) []string {
+	for len(row) < length {
+		row = append(row, "")
+	}
+	return row
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// optionalString returns nil for an empty cell, so empty cells don't overwrite anything on import
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func formatIntPtr(i *int) string {
+	if i == nil {
+		return ""
+	}
+	return strconv.Itoa(*i)
+}
+
+func parseIntPtr(s string) (*int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
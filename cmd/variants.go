@@ -0,0 +1,199 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dommmel/goshopping/shopify"
+	"github.com/spf13/viper"
+)
+
+// getProductVariants fetches the variants of a product
+func getProductVariants(productID int, store Store) ([]*shopify.Variant, error) {
+	opt := &shopify.ProductListOptions{Ids: []int{productID}, Fields: []string{"id", "variants"}}
+	products, err := store.ListProducts(opt)
+	if err != nil {
+		return nil, fmt.Errorf("Can't fetch variants of product %d: %s", productID, err)
+	}
+	if len(products) == 0 {
+		return nil, fmt.Errorf("Found no product with id %d", productID)
+	}
+	return products[0].Variants, nil
+}
+
+// variantOptions returns the option values of a variant in order
+func variantOptions(v *shopify.Variant) (options []string) {
+	for _, option := range []*string{v.Option1, v.Option2, v.Option3} {
+		if option != nil {
+			options = append(options, *option)
+		}
+	}
+	return
+}
+
+// variantLabel names a variant in messages and dry-run plans
+func variantLabel(v *VariantOutput) string {
+	if v.Sku != nil && *v.Sku != "" {
+		return *v.Sku
+	}
+	if v.Title != nil {
+		return *v.Title
+	}
+	return fmt.Sprintf("%d", *v.Id)
+}
+
+// exportVariants returns the variants of a product that have power-editor metafields.
+// If all is set, variants without metafields are included as well.
+func exportVariants(productID int, variants []*shopify.Variant, namespace string, all bool, store Store) ([]*VariantOutput, error) {
+	var out []*VariantOutput
+	for _, v := range variants {
+		ref := contentRef{kind: kindVariant, id: *v.Id, parentId: productID}
+		metafields, err := store.ListMetafields(ref, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if len(metafields) == 0 && !all {
+			continue
+		}
+		out = append(out, &VariantOutput{
+			Id:      v.Id,
+			Sku:     v.Sku,
+			Title:   v.Title,
+			Options: variantOptions(v),
+			Fields:  GenerateProductDataOutput(metafields),
+		})
+	}
+	return out, nil
+}
+
+// matchVariant finds the variant of a product that an exported variant belongs to.
+// It matches by SKU first, then by option values and finally by ID.
+func matchVariant(v *VariantOutput, variants []*shopify.Variant) *shopify.Variant {
+	if v.Sku != nil && *v.Sku != "" {
+		var found []*shopify.Variant
+		for _, candidate := range variants {
+			if candidate.Sku != nil && *candidate.Sku == *v.Sku {
+				found = append(found, candidate)
+			}
+		}
+		// SKUs aren't required to be unique, so fall through if they're ambiguous
+		if len(found) == 1 {
+			return found[0]
+		}
+	}
+	if len(v.Options) > 0 {
+		for _, candidate := range variants {
+			if equalStrings(variantOptions(candidate), v.Options) {
+				return candidate
+			}
+		}
+	}
+	if v.Id != nil {
+		for _, candidate := range variants {
+			if *candidate.Id == *v.Id {
+				return candidate
+			}
+		}
+	}
+	return nil
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchVariants pairs the exported variants with the variants of the product they're imported into.
+// Variants without a match are reported and skipped.
+func matchVariants(productID int, exported []*VariantOutput, store Store) (map[*VariantOutput]contentRef, error) {
+	variants, err := getProductVariants(productID, store)
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[*VariantOutput]contentRef)
+	for _, v := range exported {
+		match := matchVariant(v, variants)
+		if match == nil {
+			fmt.Printf("Skipping variant %s: no matching variant in product %d\n", variantLabel(v), productID)
+			continue
+		}
+		refs[v] = contentRef{kind: kindVariant, id: *match.Id, parentId: productID}
+	}
+	return refs, nil
+}
+
+// importVariants writes the metafields of exported variants to the matching variants of a product
+func importVariants(productID int, exported []*VariantOutput, prune bool, store Store) error {
+	if len(exported) == 0 {
+		return nil
+	}
+	refs, err := matchVariants(productID, exported, store)
+	if err != nil {
+		return err
+	}
+	for _, v := range exported {
+		ref, ok := refs[v]
+		if !ok {
+			continue
+		}
+		metafields := AssembleMetafieldData(v.Fields)
+		if err := ReconcileMetafields(ref, metafields, prune, store); err != nil {
+			return fmt.Errorf("variant %s: %s", variantLabel(v), err)
+		}
+	}
+	return nil
+}
+
+// planVariants returns the metafield changes an import of exported variants would make
+func planVariants(productID int, exported []*VariantOutput, store Store) ([]*FieldChange, error) {
+	if len(exported) == 0 {
+		return nil, nil
+	}
+	refs, err := matchVariants(productID, exported, store)
+	if err != nil {
+		return nil, err
+	}
+	var changes []*FieldChange
+	for _, v := range exported {
+		ref, ok := refs[v]
+		if !ok {
+			continue
+		}
+		existing, err := store.ListMetafields(ref, viper.GetString("import.namespace"))
+		if err != nil {
+			return nil, err
+		}
+		metafields := AssembleMetafieldData(v.Fields)
+		for _, c := range diffMetafields(existing, metafields, viper.GetBool("import.prune")) {
+			c.Field = "variant " + variantLabel(v) + " " + c.Field
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/dommmel/goshopping/shopify"
+)
+
+func TestMatchVariant(t *testing.T) {
+	variants := []*shopify.Variant{
+		{Id: intPtr(11), Sku: strPtr("BR-45-BLK"), Option1: strPtr("45 cm"), Option2: strPtr("black")},
+		{Id: intPtr(12), Sku: strPtr("BR-45-GRN"), Option1: strPtr("45 cm"), Option2: strPtr("green")},
+		{Id: intPtr(13), Sku: strPtr(""), Option1: strPtr("30 cm"), Option2: strPtr("black")},
+		{Id: intPtr(14), Sku: strPtr(""), Option1: strPtr("30 cm"), Option2: strPtr("green")},
+	}
+
+	tests := []struct {
+		name    string
+		variant *VariantOutput
+		want    int
+	}{
+		{"sku", &VariantOutput{Id: intPtr(99), Sku: strPtr("BR-45-GRN")}, 12},
+		{"sku wins over options", &VariantOutput{Sku: strPtr("BR-45-BLK"), Options: []string{"45 cm", "green"}}, 11},
+		{"empty sku", &VariantOutput{Id: intPtr(99), Sku: strPtr(""), Options: []string{"30 cm", "green"}}, 14},
+		{"unknown sku", &VariantOutput{Sku: strPtr("OTHER"), Options: []string{"30 cm", "black"}}, 13},
+		{"id", &VariantOutput{Id: intPtr(12)}, 12},
+	}
+	for _, test := range tests {
+		match := matchVariant(test.variant, variants)
+		if match == nil {
+			t.Errorf("%s: no match, want %d", test.name, test.want)
+		} else if *match.Id != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, *match.Id, test.want)
+		}
+	}
+
+	if match := matchVariant(&VariantOutput{Id: intPtr(99), Options: []string{"60 cm"}}, variants); match != nil {
+		t.Errorf("got %d, want no match", *match.Id)
+	}
+}
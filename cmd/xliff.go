@@ -0,0 +1,496 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+)
+
+// The XLIFF formats write every text of a record as a translation unit. Records and fields are
+// groups of units, with the attributes in xliffNamespace telling where each unit belongs, so
+// the translated file can be imported like any other data file.
+const (
+	xliff12Namespace = "urn:oasis:names:tc:xliff:document:1.2"
+	xliff20Namespace = "urn:oasis:names:tc:xliff:document:2.0"
+	xliffNamespace   = "https://github.com/flexify/powereditor-cli"
+	xliffPrefix      = "pe:"
+)
+
+// xliffNode is an element of an XLIFF document. Both versions are read and written as
+// a tree of nodes rather than typed structs, as they only differ in names.
+type xliffNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr   `xml:",any,attr"`
+	Text     string       `xml:",chardata"`
+	Children []*xliffNode `xml:",any"`
+	// Inner is the XML inside an element that has been read, so text can be read in document order
+	Inner string `xml:",innerxml"`
+}
+
+func newXliffNode(name string, attrs ...string) *xliffNode {
+	n := &xliffNode{XMLName: xml.Name{Local: name}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			n.Attrs = append(n.Attrs, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+		}
+	}
+	return n
+}
+
+func (n *xliffNode) add(child *xliffNode) *xliffNode {
+	n.Children = append(n.Children, child)
+	return child
+}
+
+// attr returns the value of an attribute. Names starting with xliffPrefix are looked up in xliffNamespace.
+func (n *xliffNode) attr(name string) string {
+	space := ""
+	if strings.HasPrefix(name, xliffPrefix) {
+		space, name = xliffNamespace, strings.TrimPrefix(name, xliffPrefix)
+	}
+	for _, a := range n.Attrs {
+		if a.Name.Local == name && (space == "" || a.Name.Space == space) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// xliffPlaceholders are the inline elements that stand for markup of the original document.
+// The markup itself isn't in the file, so units holding them can't be imported.
+var xliffPlaceholders = map[string]bool{
+	"x": true, "bx": true, "ex": true, "ph": true, "bpt": true, "ept": true, "it": true, "sc": true, "ec": true,
+}
+
+// innerText returns the text of an element and all of its descendants in document order.
+// Translation tools wrap parts of a text in inline elements such as <mrk>, <g> or <pc>.
+func (n *xliffNode) innerText() (string, error) {
+	var b strings.Builder
+	d := xml.NewDecoder(strings.NewReader(n.Inner))
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return b.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			b.Write(t)
+		case xml.StartElement:
+			if xliffPlaceholders[t.Name.Local] {
+				return "", fmt.Errorf("<%s> in <%s> stands for markup that isn't in the file", t.Name.Local, n.XMLName.Local)
+			}
+		}
+	}
+}
+
+// child returns the first child element with the given name
+func (n *xliffNode) child(name string) *xliffNode {
+	for _, c := range n.Children {
+		if c.XMLName.Local == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// xliffWriter builds the units of a document in either version
+type xliffWriter struct {
+	version string
+}
+
+// group returns a group of units with a stable id
+func (x xliffWriter) group(id string, attrs ...string) *xliffNode {
+	nameAttr := "resname"
+	if x.version == "2.0" {
+		nameAttr = "name"
+	}
+	return newXliffNode("group", append([]string{"id", x.id(id), nameAttr, id}, attrs...)...)
+}
+
+// unit returns a translation unit with a stable id and the given source text
+func (x xliffWriter) unit(id string, source string, translate bool, attrs ...string) *xliffNode {
+	no := ""
+	if !translate {
+		no = "no"
+	}
+	if x.version == "2.0" {
+		u := newXliffNode("unit", append([]string{"id", x.id(id), "name", id, "translate", no}, attrs...)...)
+		u.add(newXliffNode("segment")).add(xliffSource(source))
+		return u
+	}
+	u := newXliffNode("trans-unit", append([]string{"id", id, "resname", id, "translate", no}, attrs...)...)
+	u.add(xliffSource(source))
+	return u
+}
+
+// xliffSource returns the source of a unit. Tools are asked to keep the whitespace of multi-line sources.
+func xliffSource(text string) *xliffNode {
+	space := ""
+	if strings.ContainsAny(text, "\n\t") || strings.TrimSpace(text) != text {
+		space = "preserve"
+	}
+	n := newXliffNode("source", "xml:space", space)
+	n.Text = text
+	return n
+}
+
+// invalidXliffId matches the characters XLIFF 2.0 doesn't allow in ids
+var invalidXliffId = regexp.MustCompile(`[^\pL\pN._:-]`)
+
+func (x xliffWriter) id(key string) string {
+	if x.version == "2.0" {
+		return invalidXliffId.ReplaceAllString(strings.Replace(key, "/", ":", -1), "_")
+	}
+	return key
+}
+
+// recordKey returns the stable key of a record: its kind, followed by the handle of its parent
+// and its own handle. Records without a handle are keyed by "id:<id>", variants by
+// "sku:<sku>", "options:<option values>" or their id.
+func recordKey(r *flatRecord) string {
+	key := []string{r.kind}
+	if r.parent != nil {
+		key = append(key, contentKey(r.parent))
+	}
+	switch {
+	case r.kind == kindShop:
+	case r.kind == kindVariant && r.sku != nil && *r.sku != "":
+		key = append(key, "sku:"+*r.sku)
+	case r.kind == kindVariant && len(r.options) > 0:
+		key = append(key, "options:"+strings.Join(r.options, optionSeparator))
+	default:
+		key = append(key, contentKey(r.content))
+	}
+	for i := range key {
+		key[i] = keyEscaper.Replace(key[i])
+	}
+	return strings.Join(key, "/")
+}
+
+// keyEscaper keeps the parts of a key apart
+var keyEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+func contentKey(c *content) string {
+	if c.Handle != nil && *c.Handle != "" {
+		return *c.Handle
+	}
+	return "id:" + formatIntPtr(c.Id)
+}
+
+func writeXLIFF12(w io.Writer, output *Output) error {
+	return writeXLIFF(w, output, "1.2")
+}
+
+func writeXLIFF20(w io.Writer, output *Output) error {
+	return writeXLIFF(w, output, "2.0")
+}
+
+// writeXLIFF writes the texts of a data file as translation units. Title, description and SEO
+// tags are always translatable, metafield cells that hold URLs, handles, IDs or numbers are
+// marked translate="no".
+func writeXLIFF(w io.Writer, output *Output, version string) error {
+	source, target := viper.GetString("export.source-language"), viper.GetString("export.target-language")
+	x := xliffWriter{version: version}
+
+	var root, body *xliffNode
+	if version == "2.0" {
+		root = newXliffNode("xliff", "xmlns", xliff20Namespace, "xmlns:pe", xliffNamespace, "version", version, "srcLang", source, "trgLang", target)
+		body = root.add(newXliffNode("file", append([]string{"id", "power-editor"}, xliffHeaderAttrs(output.Header)...)...))
+	} else {
+		root = newXliffNode("xliff", "xmlns", xliff12Namespace, "xmlns:pe", xliffNamespace, "version", version)
+		attrs := []string{"original", "power-editor", "source-language", source, "target-language", target, "datatype", "html"}
+		file := root.add(newXliffNode("file", append(attrs, xliffHeaderAttrs(output.Header)...)...))
+		body = file.add(newXliffNode("body"))
+	}
+
+	for _, r := range flattenOutput(output) {
+		key := recordKey(r)
+		attrs := []string{"pe:kind", r.kind, "pe:id", formatIntPtr(r.content.Id), "pe:handle", stringValue(r.content.Handle),
+			"pe:sku", stringValue(r.sku), "pe:options", strings.Join(r.options, optionSeparator)}
+		if r.parent != nil {
+			attrs = append(attrs, "pe:parent-id", formatIntPtr(r.parent.Id), "pe:parent-handle", stringValue(r.parent.Handle))
+		}
+		g := body.add(x.group(key, attrs...))
+
+		c := r.content
+		for i, text := range []*string{c.Title, c.BodyHtml, c.MetafieldsGlobalTitleTag, c.MetafieldsGlobalDescriptionTag} {
+			if text != nil {
+				g.add(x.unit(key+"/"+contentColumns[i], *text, true, "pe:name", contentColumns[i]))
+			}
+		}
+		for _, f := range r.fields {
+			fieldKey := key + "/fields/" + stringValue(f.Key)
+			fg := g.add(x.group(fieldKey, "pe:field", stringValue(f.Key)))
+			for i, row := range f.Data {
+				for j, cell := range row {
+					id := fmt.Sprintf("%s/%d/%d", fieldKey, i, j)
+					fg.add(x.unit(id, cell, translatable(cell), "pe:row", strconv.Itoa(i), "pe:col", strconv.Itoa(j)))
+				}
+			}
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(root); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// xliffHeaderAttrs returns the attributes of the <file> element that hold the header of a data file
+func xliffHeaderAttrs(h *Header) []string {
+	var attrs []string
+	if h != nil {
+		for _, name := range headerNames {
+			attrs = append(attrs, xliffHeaderAttr(name), headerValue(h, name))
+		}
+	}
+	return attrs
+}
+
+func xliffHeaderAttr(name string) string {
+	return xliffPrefix + strings.Replace(name, "_", "-", -1)
+}
+
+var (
+	urlPattern    = regexp.MustCompile(`^([a-z][a-z0-9+.-]*:|//|/|www\.)\S*$`)
+	numberPattern = regexp.MustCompile(`^[-+]?[0-9.,]+%?$|^#[0-9a-fA-F]{3,8}$`)
+)
+
+// translatable tells if a metafield cell holds text, rather than a URL, a handle, an ID,
+// a number or a flag
+func translatable(s string) bool {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "", s == "true", s == "false":
+		return false
+	case urlPattern.MatchString(s), numberPattern.MatchString(s):
+		return false
+	case strings.ContainsAny(s, " \t\n<&"):
+		return true
+	}
+	// A single word is a handle or an ID if it contains digits, dashes or the like
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// readXLIFF reads a translated XLIFF 1.2 or 2.0 file. Units take the text of their target,
+// or of their source if they haven't been translated.
+func readXLIFF(r io.Reader) (*Output, error) {
+	var root xliffNode
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		return nil, err
+	}
+	if root.XMLName.Local != "xliff" {
+		return nil, fmt.Errorf("not an XLIFF file")
+	}
+
+	var records []*flatRecord
+	var walk func(n *xliffNode) error
+	walk = func(n *xliffNode) error {
+		for _, c := range n.Children {
+			if c.XMLName.Local != "group" || c.attr("pe:kind") == "" {
+				if err := walk(c); err != nil {
+					return err
+				}
+				continue
+			}
+			record, err := readXliffRecord(c)
+			if err != nil {
+				return fmt.Errorf("group %s: %v", c.attr("id"), err)
+			}
+			records = append(records, record)
+		}
+		return nil
+	}
+	if err := walk(&root); err != nil {
+		return nil, err
+	}
+
+	header := &Header{}
+	if file := root.child("file"); file != nil {
+		for _, name := range headerNames {
+			if value := file.attr(xliffHeaderAttr(name)); value != "" {
+				if err := setHeaderValue(header, name, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return unflattenOutput(header, records)
+}
+
+// readXliffRecord reads a record from its group
+func readXliffRecord(g *xliffNode) (*flatRecord, error) {
+	var err error
+	r := &flatRecord{kind: g.attr("pe:kind"), content: &content{}, sku: optionalString(g.attr("pe:sku"))}
+	if r.content.Id, err = parseIntPtr(g.attr("pe:id")); err != nil {
+		return nil, err
+	}
+	r.content.Handle = optionalString(g.attr("pe:handle"))
+	if g.attr("pe:parent-id") != "" || g.attr("pe:parent-handle") != "" {
+		r.parent = &content{Handle: optionalString(g.attr("pe:parent-handle"))}
+		if r.parent.Id, err = parseIntPtr(g.attr("pe:parent-id")); err != nil {
+			return nil, err
+		}
+	}
+	if options := g.attr("pe:options"); options != "" {
+		r.options = strings.Split(options, optionSeparator)
+	}
+
+	c := r.content
+	texts := map[string]**string{
+		"title":                             &c.Title,
+		"body_html":                         &c.BodyHtml,
+		"metafields_global_title_tag":       &c.MetafieldsGlobalTitleTag,
+		"metafields_global_description_tag": &c.MetafieldsGlobalDescriptionTag,
+	}
+	for _, n := range g.Children {
+		if name := n.attr("pe:name"); texts[name] != nil {
+			text, err := xliffText(n)
+			if err != nil {
+				return nil, err
+			}
+			*texts[name] = &text
+		}
+		if key := n.attr("pe:field"); key != "" && n.XMLName.Local == "group" {
+			field, err := readXliffField(key, n)
+			if err != nil {
+				return nil, err
+			}
+			r.fields = append(r.fields, field)
+		}
+	}
+	return r, nil
+}
+
+// readXliffField reads the cells of a field from its group
+func readXliffField(key string, g *xliffNode) (*OutputField, error) {
+	cells := make(map[int]map[int]string)
+	for _, u := range g.Children {
+		if u.attr("pe:row") == "" {
+			continue
+		}
+		i, err := strconv.Atoi(u.attr("pe:row"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid row number '%s'", u.attr("pe:row"))
+		}
+		j, err := strconv.Atoi(u.attr("pe:col"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid column number '%s'", u.attr("pe:col"))
+		}
+		if cells[i] == nil {
+			cells[i] = make(map[int]string)
+		}
+		if cells[i][j], err = xliffText(u); err != nil {
+			return nil, err
+		}
+	}
+
+	return &OutputField{Key: &key, Data: fieldDataFromCells(cells)}, nil
+}
+
+// fieldDataFromCells returns the rows of a field from its cells by row and column number
+func fieldDataFromCells(cells map[int]map[int]string) FieldData {
+	var rows []int
+	for i := range cells {
+		rows = append(rows, i)
+	}
+	sort.Ints(rows)
+
+	data := FieldData{}
+	for _, i := range rows {
+		width := 0
+		for j := range cells[i] {
+			if j+1 > width {
+				width = j + 1
+			}
+		}
+		row := make([]string, width)
+		for j, cell := range cells[i] {
+			row[j] = cell
+		}
+		data = append(data, row)
+	}
+	return data
+}
+
+// xliffText returns the translation of a unit, or its source text if there is none.
+// XLIFF 2.0 units may be split into several segments.
+func xliffText(u *xliffNode) (string, error) {
+	segments := []*xliffNode{u}
+	if u.XMLName.Local == "unit" {
+		segments = nil
+		for _, s := range u.Children {
+			if s.XMLName.Local == "segment" || s.XMLName.Local == "ignorable" {
+				segments = append(segments, s)
+			}
+		}
+	}
+	var source, target string
+	translated := false
+	for _, s := range segments {
+		var sourceText string
+		if n := s.child("source"); n != nil {
+			text, err := n.innerText()
+			if err != nil {
+				return "", fmt.Errorf("unit %s: %v", u.attr("id"), err)
+			}
+			sourceText = text
+		}
+		source += sourceText
+		if n := s.child("target"); n != nil {
+			text, err := n.innerText()
+			if err != nil {
+				return "", fmt.Errorf("unit %s: %v", u.attr("id"), err)
+			}
+			target += text
+			translated = translated || text != ""
+		} else {
+			target += sourceText
+		}
+	}
+	if translated {
+		return target, nil
+	}
+	return source, nil
+}
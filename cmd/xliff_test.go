@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadXLIFFTargets(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeXLIFF12(&buf, testOutput()); err != nil {
+		t.Fatal(err)
+	}
+	translated := strings.Replace(buf.String(),
+		"<source>BLACKROLL® MED 45</source>",
+		"<source>BLACKROLL® MED 45</source>\n<target>BLACKROLL® MED 45 (EN)</target>", 1)
+	translated = strings.Replace(translated,
+		"<source>Sale</source>",
+		"<source>Sale</source><target state=\"translated\">Soldes</target>", 1)
+
+	got, err := readXLIFF(strings.NewReader(translated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := *got.Products[0].Title; title != "BLACKROLL® MED 45 (EN)" {
+		t.Errorf("got title %q", title)
+	}
+	if banner := metafieldValue(got.Shop.Fields[0]); banner != "Soldes<!--|col|-->50%" {
+		t.Errorf("got banner %q", banner)
+	}
+}
+
+func TestReadXLIFFInlineElements(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeXLIFF12(&buf, testOutput()); err != nil {
+		t.Fatal(err)
+	}
+	translated := strings.Replace(buf.String(),
+		"<source>About us</source>",
+		`<source>About us</source><target><mrk mtype="seg" mid="1">Hello world</mrk></target>`, 1)
+	translated = strings.Replace(translated,
+		"<source>Sale</source>",
+		`<source>Sale</source><target>So<g id="1">ld</g>es</target>`, 1)
+
+	got, err := readXLIFF(strings.NewReader(translated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := *got.Pages[0].Title; title != "Hello world" {
+		t.Errorf("got title %q", title)
+	}
+	if banner := metafieldValue(got.Shop.Fields[0]); banner != "Soldes<!--|col|-->50%" {
+		t.Errorf("got banner %q", banner)
+	}
+
+	// Placeholders stand for markup that can't be restored
+	buf.Reset()
+	if err := writeXLIFF20(&buf, testOutput()); err != nil {
+		t.Fatal(err)
+	}
+	translated = strings.Replace(buf.String(),
+		"<source>About us</source>",
+		`<source>About us</source><target>About <ph id="1"/>us</target>`, 1)
+	if _, err := readXLIFF(strings.NewReader(translated)); err == nil {
+		t.Error("expected an error for a placeholder")
+	}
+}
+
+func TestTranslatable(t *testing.T) {
+	cases := map[string]bool{
+		"GRÖSSE & GEWICHT":                      true,
+		"Übung":                                 true,
+		"<p>Hallo</p>":                          true,
+		"https://www.blackroll.com/de/uebungen": false,
+		"/collections/all":                      false,
+		"XGBQkxcM8DI":                           false,
+		"blackroll-mat":                         false,
+		"158":                                   false,
+		"50%":                                   false,
+		"#ff0000":                               false,
+		"false":                                 false,
+		"":                                      false,
+	}
+	for s, want := range cases {
+		if got := translatable(s); got != want {
+			t.Errorf("translatable(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
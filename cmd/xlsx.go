@@ -0,0 +1,172 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+)
+
+// xlsxContentSheet is the sheet holding the content of all records of a workbook
+const xlsxContentSheet = "content"
+
+// xlsxHeaderSheet is the sheet holding the header of the data file, a row for each of its values
+const xlsxHeaderSheet = "header"
+
+// xlsxMaxCellLength is the number of characters a cell can hold. Longer values would be cut off.
+const xlsxMaxCellLength = 32767
+
+// invalidSheetName matches the characters that aren't allowed in sheet names
+var invalidSheetName = regexp.MustCompile(`[\[\]:*?/\\]`)
+
+// writeXLSX writes a data file as a workbook. The content sheet holds a row with the content
+// of every record, followed by a sheet per field key with a row for each row of the field
+// and the header sheet.
+func writeXLSX(w io.Writer, output *Output) error {
+	records := flattenOutput(output)
+
+	// Collect the fields by key, in the order they first appear
+	var keys []string
+	fields := make(map[string][]*OutputField)
+	owners := make(map[*OutputField]*flatRecord)
+	for _, r := range records {
+		for _, f := range r.fields {
+			key := stringValue(f.Key)
+			if fields[key] == nil {
+				keys = append(keys, key)
+			}
+			fields[key] = append(fields[key], f)
+			owners[f] = r
+		}
+	}
+
+	x := excelize.NewFile()
+	x.SetSheetName("Sheet1", xlsxContentSheet)
+	table := [][]string{tableHeader(0, identityColumns, contentColumns)}
+	for _, r := range records {
+		table = append(table, append(identityCells(r), contentCells(r)...))
+	}
+	if err := writeSheet(x, xlsxContentSheet, table); err != nil {
+		return err
+	}
+
+	used := map[string]bool{xlsxContentSheet: true, xlsxHeaderSheet: true}
+	for _, key := range keys {
+		table := [][]string{tableHeader(fieldWidth(fields[key]), identityColumns, fieldColumns)}
+		for _, f := range fields[key] {
+			identity := identityCells(owners[f])
+			for _, cells := range fieldCells(f) {
+				table = append(table, append(append([]string{}, identity...), cells...))
+			}
+		}
+		name := sheetName(key, used)
+		x.NewSheet(name)
+		if err := writeSheet(x, name, table); err != nil {
+			return err
+		}
+	}
+
+	if output.Header != nil {
+		columns := []string{"kind", "field", "0"}
+		x.NewSheet(xlsxHeaderSheet)
+		if err := writeSheet(x, xlsxHeaderSheet, append([][]string{columns}, headerRows(output.Header, columns)...)); err != nil {
+			return err
+		}
+	}
+	return x.Write(w)
+}
+
+// writeSheet writes a table to a sheet, starting at the top left cell
+func writeSheet(x *excelize.File, sheet string, table [][]string) error {
+	for i, row := range table {
+		for _, cell := range row {
+			if len([]rune(cell)) > xlsxMaxCellLength {
+				return fmt.Errorf("sheet %s row %d: a cell is longer than the %d characters XLSX allows, use another format", sheet, i+1, xlsxMaxCellLength)
+			}
+		}
+		axis, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		row := row
+		if err := x.SetSheetRow(sheet, axis, &row); err != nil {
+			return err
+		}
+	}
+	return x.SetPanes(sheet, `{"freeze":true,"split":false,"x_split":0,"y_split":1,"top_left_cell":"A2","active_pane":"bottomLeft"}`)
+}
+
+// sheetName returns a valid, unused sheet name for a field key. Sheet names are only
+// for display, the key of a field is read from its "field" column.
+func sheetName(key string, used map[string]bool) string {
+	name := invalidSheetName.ReplaceAllString(key, "_")
+	if r := []rune(name); len(r) > 31 {
+		name = string(r[:31])
+	}
+	if name == "" {
+		name = "field"
+	}
+	// Sheet names are case insensitive
+	unique := name
+	for i := 2; used[strings.ToLower(unique)]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		r := []rune(name)
+		if len(r)+len(suffix) > 31 {
+			r = r[:31-len(suffix)]
+		}
+		unique = string(r) + suffix
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// readXLSX reads a workbook written by writeXLSX
+func readXLSX(r io.Reader) (*Output, error) {
+	x, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the sheets in order, so records keep the order of the content sheet
+	sheets := x.GetSheetMap()
+	var indexes []int
+	for i := range sheets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	t := newTableReader()
+	for _, i := range indexes {
+		rows, err := x.GetRows(sheets[i])
+		if err != nil {
+			return nil, err
+		}
+		if err := t.add("sheet "+sheets[i], rows); err != nil {
+			return nil, err
+		}
+	}
+	return t.output()
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+)
+
+func TestXLSXSheetNames(t *testing.T) {
+	// A field whose key is taken by the content sheet
+	output := testOutput()
+	output.Pages[0].Fields = []*OutputField{{Key: strPtr("Content"), Data: FieldData{{"intro", "<p>Hello</p>"}}}}
+
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, output); err != nil {
+		t.Fatal(err)
+	}
+	x, err := excelize.OpenReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := 1; i <= len(x.GetSheetMap()); i++ {
+		names = append(names, x.GetSheetName(i))
+	}
+	if got, want := strings.Join(names, ","), "content,accordion,tabs,size,Content (2),video,banner,header"; got != want {
+		t.Errorf("got sheets %s, want %s", got, want)
+	}
+
+	got, err := readXLSX(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameOutput(t, got, output)
+}
+
+func TestXLSXCellLength(t *testing.T) {
+	output := testOutput()
+	long := strings.Repeat("ü", xlsxMaxCellLength+1)
+	output.Products[0].BodyHtml = &long
+	if err := writeXLSX(&bytes.Buffer{}, output); err == nil {
+		t.Error("expected an error for a cell that doesn't fit")
+	}
+}
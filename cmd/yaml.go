@@ -0,0 +1,238 @@
+// Copyright © 2017 flexify.net
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// YAML and TOML files are converted from and to JSON, so they share its field names,
+// its omitted fields and its migrations.
+
+func writeYAML(w io.Writer, output *Output) error {
+	b, err := marshalYAML(output)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readYAML(r io.Reader) (*Output, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err = yamlToJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := decodeOutput(b)
+	return data, err
+}
+
+// marshalYAML returns v as YAML, in the order of its JSON fields. Multi-line strings
+// like HTML descriptions are written as literal block scalars.
+func marshalYAML(v interface{}) ([]byte, error) {
+	b, err := JSONMarshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	tree, err := orderedTree(d)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(tree)
+}
+
+func unmarshalYAML(b []byte, v interface{}) error {
+	b, err := yamlToJSON(b)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func yamlToJSON(b []byte) ([]byte, error) {
+	var tree interface{}
+	if err := yaml.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+	return json.Marshal(stringKeys(tree))
+}
+
+// orderedTree reads the next JSON value as a tree of yaml.MapSlice, keeping the order of
+// object keys. Null values are left out and numbers are turned into integers where possible.
+func orderedTree(d *json.Decoder) (interface{}, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case json.Delim:
+		if t == '{' {
+			m := yaml.MapSlice{}
+			for d.More() {
+				key, err := d.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := orderedTree(d)
+				if err != nil {
+					return nil, err
+				}
+				if value != nil {
+					m = append(m, yaml.MapItem{Key: key, Value: value})
+				}
+			}
+			_, err := d.Token()
+			return m, err
+		}
+		a := []interface{}{}
+		for d.More() {
+			value, err := orderedTree(d)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, value)
+		}
+		_, err := d.Token()
+		return a, err
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	}
+	return t, nil
+}
+
+// stringKeys turns the maps decoded from YAML into maps with string keys, as JSON requires
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = stringKeys(value)
+		}
+		return m
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = stringKeys(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = stringKeys(value)
+		}
+	}
+	return v
+}
+
+func writeTOML(w io.Writer, output *Output) error {
+	b, err := marshalTOML(output)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readTOML(r io.Reader) (*Output, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err = tomlToJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := decodeOutput(b)
+	return data, err
+}
+
+// marshalTOML returns v as TOML. TOML has no null, so null values are left out.
+func marshalTOML(v interface{}) ([]byte, error) {
+	b, err := JSONMarshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	tree, err := orderedTree(d)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = toml.NewEncoder(&buf).Encode(plainTree(tree))
+	return buf.Bytes(), err
+}
+
+func unmarshalTOML(b []byte, v interface{}) error {
+	b, err := tomlToJSON(b)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func tomlToJSON(b []byte) ([]byte, error) {
+	var tree map[string]interface{}
+	if _, err := toml.Decode(string(b), &tree); err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+// plainTree turns the yaml.MapSlices of an ordered tree into maps. Arrays of objects
+// become []map[string]interface{}, which TOML writes as arrays of tables.
+func plainTree(v interface{}) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		m := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			m[fmt.Sprint(item.Key)] = plainTree(item.Value)
+		}
+		return m
+	case []interface{}:
+		tables := make([]map[string]interface{}, 0, len(v))
+		for _, value := range v {
+			if m, ok := plainTree(value).(map[string]interface{}); ok {
+				tables = append(tables, m)
+			}
+		}
+		if len(v) > 0 && len(tables) == len(v) {
+			return tables
+		}
+		for i, value := range v {
+			v[i] = plainTree(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestYAMLBlockScalars(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeYAML(&buf, testOutput()); err != nil {
+		t.Fatal(err)
+	}
+	want := "    - - Row 0\n      - |-\n        <ul>\n          <li>45 cm x 15 cm, 158 g&nbsp;</li>\n        </ul>\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("multi-line HTML isn't a block scalar:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "null") {
+		t.Errorf("null values should be left out:\n%s", buf.String())
+	}
+}
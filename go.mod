@@ -3,10 +3,12 @@ module github.com/flexify/powereditor-cli
 go 1.12
 
 require (
+	github.com/360EntSecGroup-Skylar/excelize/v2 v2.0.2
+	github.com/BurntSushi/toml v0.3.1
 	github.com/caarlos0/spin v1.1.0
 	github.com/davecgh/go-spew v1.1.1
 	github.com/dommmel/goshopping v0.0.4
-	github.com/google/go-querystring v1.0.0 // indirect
+	github.com/google/go-querystring v1.0.0
 	github.com/magiconair/properties v1.8.1 // indirect
 	github.com/pelletier/go-toml v1.5.0 // indirect
 	github.com/spf13/afero v1.2.2 // indirect
@@ -17,4 +19,5 @@ require (
 	github.com/stretchr/testify v1.3.0 // indirect
 	golang.org/x/sys v0.0.0-20191010194322-b09406accb47 // indirect
 	golang.org/x/text v0.3.2 // indirect
+	gopkg.in/yaml.v2 v2.2.4
 )